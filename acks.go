@@ -3,26 +3,54 @@ package socketio
 import (
 	"errors"
 	"sync"
-
-	"github.com/mtfelian/synced"
+	"time"
 )
 
 var (
 	ErrorAckWaiterNotFound = errors.New("ack waiter not found")
 )
 
+// maxAckId is the maximum ack id, ids wrap around to 1 after it
+const maxAckId = 1<<31 - 1
+
 // acks represents chans needed for Ack messages to work
 type acks struct {
-	count synced.Counter
+	lastId  int           // the last allocated ack id
+	timeout time.Duration // ack response timeout of the callbacks, the transport ping timeout is used if zero
 
-	ackC  map[int]chan string
-	ackMu sync.RWMutex
+	ackC      map[int]chan string
+	callbacks map[int]*ackCallback
+	ackMu     sync.RWMutex
 }
 
-// nextId of ack waiter
+// ackCallback is a function called with the ack response, its timer fires on the response timeout
+type ackCallback struct {
+	f     func(data string, err error)
+	timer *time.Timer
+}
+
+// nextId of ack waiter, ids are bounded by maxAckId and the ones of the outstanding acks are skipped
 func (a *acks) nextId() int {
-	a.count.Inc()
-	return a.count.Get()
+	a.ackMu.Lock()
+	defer a.ackMu.Unlock()
+	for {
+		a.lastId++
+		if a.lastId <= 0 || a.lastId > maxAckId {
+			a.lastId = 1
+		}
+		_, waiting := a.ackC[a.lastId]
+		_, callback := a.callbacks[a.lastId]
+		if !waiting && !callback {
+			return a.lastId
+		}
+	}
+}
+
+// pending returns the amount of the outstanding acks
+func (a *acks) pending() int {
+	a.ackMu.RLock()
+	defer a.ackMu.RUnlock()
+	return len(a.ackC) + len(a.callbacks)
 }
 
 // register new ack request waiter
@@ -50,3 +78,34 @@ func (a *acks) obtain(id int) (chan string, error) {
 
 	return nil, ErrorAckWaiterNotFound
 }
+
+// registerCallback registers the function f called with the ack response of the given id. If the response
+// doesn't arrive within the timeout, f is called with ErrorAckTimeout and unregistered
+func (a *acks) registerCallback(id int, f func(data string, err error), timeout time.Duration) {
+	a.ackMu.Lock()
+	defer a.ackMu.Unlock()
+	if a.callbacks == nil {
+		a.callbacks = make(map[int]*ackCallback)
+	}
+	a.callbacks[id] = &ackCallback{
+		f: f,
+		timer: time.AfterFunc(timeout, func() {
+			if cb := a.takeCallback(id); cb != nil {
+				cb.f("", ErrorAckTimeout)
+			}
+		}),
+	}
+}
+
+// takeCallback unregisters and returns the callback by ack id, it returns nil if there is no such callback
+func (a *acks) takeCallback(id int) *ackCallback {
+	a.ackMu.Lock()
+	defer a.ackMu.Unlock()
+	cb, ok := a.callbacks[id]
+	if !ok {
+		return nil
+	}
+	delete(a.callbacks, id)
+	cb.timer.Stop()
+	return cb
+}
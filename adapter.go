@@ -0,0 +1,241 @@
+package socketio
+
+import (
+	"strings"
+	"sync"
+)
+
+// Adapter keeps the membership of the channels in the rooms and delivers the broadcasts to them. The default
+// MemoryAdapter keeps everything in memory of the node. An adapter of a multi-node deployment, e.g. based on
+// Redis pub/sub, publishes the broadcasts to the other nodes and delivers both the local and the received ones
+// to the local channels, e.g. by embedding MemoryAdapter
+type Adapter interface {
+	// Join adds the channel c to the room of its namespace, it returns false if c is already joined to it
+	Join(c *Channel, room string) bool
+
+	// Leave removes the channel c from the room, it returns false if c isn't joined to it
+	Leave(c *Channel, room string) bool
+
+	// LeaveAll removes the channel c from all the rooms and returns their names
+	LeaveAll(c *Channel) []string
+
+	// Replace moves the channel old to the rooms of the channel c replacing it at the transport upgrade
+	Replace(old, c *Channel)
+
+	// Rooms returns names of the rooms the channel c is joined to
+	Rooms(c *Channel) []string
+
+	// List returns the channels joined to the room of the namespace
+	List(namespace, room string) []*Channel
+
+	// Amount returns an amount of the channels joined to the room of the namespace
+	Amount(namespace, room string) int
+
+	// RoomNames returns names of the rooms of the namespace with at least one joined channel
+	RoomNames(namespace string) []string
+
+	// CountRooms returns an amount of the rooms of all the namespaces with at least one joined channel
+	CountRooms() int
+
+	// Broadcast delivers the broadcast b to the channels joined to its rooms, it shouldn't wait for the delivery
+	Broadcast(b *Broadcast)
+}
+
+// Broadcast is an event broadcast to the rooms of the namespace, it's delivered once per channel
+// even if the channel is joined to several of the rooms
+type Broadcast struct {
+	All       bool // the event is delivered to all the channels of every namespace, Namespace and Rooms are ignored
+	Namespace string
+	Rooms     []string
+	Except    string // id of the channel the event isn't delivered to, empty means none
+	Event     string
+	Payload   interface{}
+}
+
+// SetAdapter sets the adapter a keeping the rooms and delivering the broadcasts, the MemoryAdapter
+// is used by default. It should be set before serving the connections
+func (s *Server) SetAdapter(a Adapter) { s.adapter = a }
+
+// MemoryAdapter is an Adapter keeping the rooms in memory and delivering the broadcasts to the channels
+// of the server
+type MemoryAdapter struct {
+	server *Server
+
+	channels map[roomKey]map[*Channel]struct{} // maps room to map of channels to an empty struct
+	rooms    map[*Channel]map[string]struct{}  // maps channel to map of room names to an empty struct
+	mu       sync.RWMutex
+}
+
+// NewMemoryAdapter returns the MemoryAdapter delivering the broadcasts to the channels of the server s
+func NewMemoryAdapter(s *Server) *MemoryAdapter {
+	return &MemoryAdapter{
+		server:   s,
+		channels: make(map[roomKey]map[*Channel]struct{}),
+		rooms:    make(map[*Channel]map[string]struct{}),
+	}
+}
+
+// Join adds the channel c to the room of its namespace, it returns false if c is already joined to it
+func (a *MemoryAdapter) Join(c *Channel, room string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, joined := a.rooms[c][room]; joined {
+		return false
+	}
+
+	key := roomKey{namespace: c.namespace, room: room}
+	if _, ok := a.channels[key]; !ok {
+		a.channels[key] = make(map[*Channel]struct{})
+	}
+	if _, ok := a.rooms[c]; !ok {
+		a.rooms[c] = make(map[string]struct{})
+	}
+
+	a.channels[key][c], a.rooms[c][room] = struct{}{}, struct{}{}
+	return true
+}
+
+// Leave removes the channel c from the room, it returns false if c isn't joined to it
+func (a *MemoryAdapter) Leave(c *Channel, room string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, joined := a.rooms[c][room]; !joined {
+		return false
+	}
+
+	a.leave(c, room)
+	delete(a.rooms[c], room)
+	if len(a.rooms[c]) == 0 {
+		delete(a.rooms, c)
+	}
+	return true
+}
+
+// LeaveAll removes the channel c from all the rooms and returns their names
+func (a *MemoryAdapter) LeaveAll(c *Channel) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rooms := make([]string, 0, len(a.rooms[c]))
+	for room := range a.rooms[c] {
+		a.leave(c, room)
+		rooms = append(rooms, room)
+	}
+	delete(a.rooms, c)
+	return rooms
+}
+
+// leave removes the channel c from the room channels, mu should be locked
+func (a *MemoryAdapter) leave(c *Channel, room string) {
+	key := roomKey{namespace: c.namespace, room: room}
+	if roomChannels, ok := a.channels[key]; ok {
+		delete(roomChannels, c)
+		if len(roomChannels) == 0 {
+			delete(a.channels, key)
+		}
+	}
+}
+
+// Replace moves the channel old to the rooms of the channel c replacing it at the transport upgrade
+func (a *MemoryAdapter) Replace(old, c *Channel) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rooms, ok := a.rooms[old]
+	if !ok {
+		return
+	}
+	for room := range rooms {
+		key := roomKey{namespace: old.namespace, room: room}
+		delete(a.channels[key], old)
+		a.channels[key][c] = struct{}{}
+	}
+	a.rooms[c] = rooms
+	delete(a.rooms, old)
+}
+
+// Rooms returns names of the rooms the channel c is joined to
+func (a *MemoryAdapter) Rooms(c *Channel) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	rooms := make([]string, 0, len(a.rooms[c]))
+	for room := range a.rooms[c] {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// List returns the channels joined to the room of the namespace
+func (a *MemoryAdapter) List(namespace, room string) []*Channel {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	roomChannels := a.channels[roomKey{namespace: namespace, room: room}]
+	channels := make([]*Channel, 0, len(roomChannels))
+	for c := range roomChannels {
+		channels = append(channels, c)
+	}
+	return channels
+}
+
+// Amount returns an amount of the channels joined to the room of the namespace
+func (a *MemoryAdapter) Amount(namespace, room string) int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.channels[roomKey{namespace: namespace, room: room}])
+}
+
+// RoomNames returns names of the rooms of the namespace with at least one joined channel
+func (a *MemoryAdapter) RoomNames(namespace string) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	rooms := make([]string, 0)
+	for key := range a.channels {
+		if key.namespace == namespace {
+			rooms = append(rooms, key.room)
+		}
+	}
+	return rooms
+}
+
+// CountRooms returns an amount of the rooms of all the namespaces with at least one joined channel
+func (a *MemoryAdapter) CountRooms() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.channels)
+}
+
+// Broadcast emits the event of the broadcast b to the alive channels of the server joined to its rooms
+func (a *MemoryAdapter) Broadcast(b *Broadcast) {
+	var channels []*Channel
+	if b.All {
+		channels = a.server.aliveChannelsExcept(b.Except)
+	} else {
+		channels = recipients(a, b)
+	}
+	a.server.metrics.OnBroadcast(strings.Join(b.Rooms, ","), b.Event, len(channels))
+	go a.server.emitEach(channels, b.Event, b.Payload)
+}
+
+// recipients returns the alive channels joined to the rooms of the broadcast b listed by the adapter a,
+// every channel is returned once
+func recipients(a Adapter, b *Broadcast) []*Channel {
+	visited := make(map[*Channel]struct{})
+	recipients := make([]*Channel, 0)
+	for _, room := range b.Rooms {
+		for _, c := range a.List(b.Namespace, room) {
+			if _, ok := visited[c]; ok {
+				continue
+			}
+			visited[c] = struct{}{}
+			if c.IsAlive() && (b.Except == "" || c.Id() != b.Except) {
+				recipients = append(recipients, c)
+			}
+		}
+	}
+	return recipients
+}
@@ -0,0 +1,57 @@
+package socketio
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingAdapter is the MemoryAdapter recording the broadcasts, as the adapter publishing them to the other nodes
+type recordingAdapter struct {
+	*MemoryAdapter
+	broadcasts []Broadcast
+	mu         sync.Mutex
+}
+
+func (a *recordingAdapter) Broadcast(b *Broadcast) {
+	a.mu.Lock()
+	a.broadcasts = append(a.broadcasts, *b)
+	a.mu.Unlock()
+	a.MemoryAdapter.Broadcast(b)
+}
+
+func TestBroadcastToAllThroughAdapter(t *testing.T) {
+	s := NewServer(nil, nil, nil)
+	adapter := &recordingAdapter{MemoryAdapter: NewMemoryAdapter(s)}
+	s.SetAdapter(adapter)
+
+	first, second := newTestChannel(s, "first"), newTestChannel(s, "second")
+	onConnection(first)
+	onConnection(second)
+
+	s.BroadcastToAll("all", 1)
+	s.BroadcastToAllExcept(first, "except", 2)
+
+	adapter.mu.Lock()
+	broadcasts := adapter.broadcasts
+	adapter.mu.Unlock()
+	if len(broadcasts) != 2 {
+		t.Fatalf("adapter got %d broadcasts, want 2", len(broadcasts))
+	}
+	if b := broadcasts[0]; !b.All || b.Except != "" || b.Event != "all" {
+		t.Errorf("BroadcastToAll() published %+v", b)
+	}
+	if b := broadcasts[1]; !b.All || b.Except != "first" || b.Event != "except" {
+		t.Errorf("BroadcastToAllExcept() published %+v", b)
+	}
+
+	// the memory adapter delivers both broadcasts to the second channel and only the first one to the excluded
+	deadline := time.Now().Add(time.Second)
+	for (len(first.outC) < 1 || len(second.outC) < 2) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if len(first.outC) != 1 || len(second.outC) != 2 {
+		t.Fatalf("queued %d and %d messages, want 1 and 2", len(first.outC), len(second.outC))
+	}
+}
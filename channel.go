@@ -4,62 +4,204 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"go.uber.org/zap"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/vanti-dev/golang-socketio/logging"
 	"github.com/vanti-dev/golang-socketio/protocol"
 	"github.com/vanti-dev/golang-socketio/transport"
 )
 
 const (
 	queueBufferSize = 500
-	headerForward   = "X-Forwarded-For"
+	// minOutBufferSize is the minimum size of the outgoing messages queue, the smaller queue overflows
+	// on the first messages
+	minOutBufferSize = 16
+	headerForward    = "X-Forwarded-For"
+)
+
+// disconnection reasons passed to the OnDisconnection handler
+const (
+	DisconnectReasonServer    = "server disconnect"
+	DisconnectReasonShutdown  = "server shutdown"
+	DisconnectReasonRateLimit = "rate limit exceeded"
+	DisconnectReasonIdle      = "idle timeout"
+	DisconnectReasonSlow      = "slow consumer"
+
+	DisconnectReasonClient         = "client disconnect"
+	DisconnectReasonPingTimeout    = "ping timeout"
+	DisconnectReasonTransportError = "transport error"
+	DisconnectReasonProtocolError  = "protocol error"
+	DisconnectReasonOverflow       = "send queue overflow"
+	DisconnectReasonConnectTimeout = "connect timeout"
 )
 
 var (
-	ErrorSendTimeout     = errors.New("timeout")
+	ErrorSendTimeout   = errors.New("timeout")
+	ErrorAckTimeout    = errors.New("ack timeout")
+	ErrorChannelClosed = errors.New("channel is closed")
+
+	// Deprecated: ErrorSendQueueTimeout is returned instead, it matches ErrorSocketOverflood with errors.Is
 	ErrorSocketOverflood = errors.New("socket overflood")
+
+	// ErrorRawPacketNotAllowed is returned by SendRaw for the binary and the close packets, they're handled
+	// by the outgoing loop specially
+	ErrorRawPacketNotAllowed = errors.New("packet can't be sent raw")
+
+	// ErrorMessageDropped is returned by EmitVolatile if the message is dropped, as the outgoing buffer is full
+	// or the outbox exceeds the maximum size set by Server.SetMaxOutboxSize
+	ErrorMessageDropped = errors.New("message dropped")
+
+	// ErrorSendQueueTimeout is returned if the message can't be queued to be sent within the transport
+	// send timeout, as the queue is full because the peer doesn't read messages
+	ErrorSendQueueTimeout error = sendQueueError{}
 )
 
-// connectionHeader represents engine.io connection header
-type connectionHeader struct {
+// sendQueueError is the type of ErrorSendQueueTimeout, it replaces ErrorSocketOverflood and matches it
+type sendQueueError struct{}
+
+// Error implements error interface
+func (sendQueueError) Error() string { return "timeout waiting for the send queue" }
+
+// Is reports whether the target is ErrorSocketOverflood, so the callers checking for it keep matching
+func (sendQueueError) Is(target error) bool { return target == ErrorSocketOverflood }
+
+// ConnectionHeader represents engine.io connection header, it's sent in the open packet
+type ConnectionHeader struct {
 	Sid          string   `json:"sid"`
 	Upgrades     []string `json:"upgrades"`
-	PingInterval int      `json:"pingInterval"`
-	PingTimeout  int      `json:"pingTimeout"`
+	PingInterval int      `json:"pingInterval"` // in milliseconds
+	PingTimeout  int      `json:"pingTimeout"`  // in milliseconds
+
+	// Extra are the custom fields added to the header object, they shouldn't collide with the fields above
+	Extra map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON implements json.Marshaler, Extra fields are added to the header object
+func (h ConnectionHeader) MarshalJSON() ([]byte, error) {
+	type header ConnectionHeader // prevents the recursion
+	b, err := json.Marshal(header(h))
+	if err != nil || len(h.Extra) == 0 {
+		return b, err
+	}
+
+	extra, err := json.Marshal(h.Extra)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(b[:len(b)-1], ','), extra[1:]...), nil
 }
 
 // Channel represents socket.io connection
 type Channel struct {
+	// the atomically accessed 64-bit fields go first, so they're 64-bit aligned on the 32-bit platforms
+	lastActivity int64 // unix time in nanoseconds of the last event sent or received
+
 	conn transport.Connection
 
 	outC       chan string
 	stubC      chan string
 	upgradedC  chan string
-	connHeader connectionHeader
+	closedC    chan struct{}
+	receivedC  chan struct{}    // signals that a message was received, used by heartbeat
+	connHeader ConnectionHeader // the client channel receives it with the open packet, guarded by aliveMu then
+
+	binaryC  chan [][]byte // attachments of the binary packets queued at outC, in the same order
+	binaryMu sync.Mutex
+	outMu    sync.Mutex    // serializes the outbox size checks with queueing
+	outStopC chan struct{} // closed to stop the outgoing loop at the transport upgrade
+	outDoneC chan struct{} // closed when the outgoing loop exits
+
+	outBufferSize int           // size of the outgoing messages queue
+	maxOutboxSize int           // the channel is closed if more messages are queued, zero means no limit
+	idleTimeout   time.Duration // the channel is closed if no event is sent or received within it, zero means no limit
+	sendTimeout   time.Duration // maximum time to wait for a place in the full queue, zero means no limit
+	ackTimeout    time.Duration // ack response timeout of EmitWithAck, the transport ping timeout is used if zero
+	pingInterval  time.Duration // the transport ping interval is used if zero
+	pingTimeout   time.Duration // the transport ping timeout is used if zero
+
+	eventsC         chan *protocol.Message // incoming events handled in order by the worker, nil if they're handled concurrently
+	eventsQueueSize int
+
+	coalesced   map[string]*coalescedEmit // events waiting for the end of their coalescing window by name
+	coalescedMu sync.Mutex
 
 	alive   bool
+	state   ConnectionState
 	aliveMu sync.Mutex
 
-	ack *acks
+	connectPending int32 // set while the channel awaits the CONNECT packet, accessed atomically
+
+	upgraded   *Channel // the channel replacing this one after the transport upgrade
+	upgradedMu sync.Mutex
+
+	reason    string // disconnection reason
+	auth      string // auth payload of the CONNECT packet
+	closeCode int    // websocket close code, the normal closure one is used if zero
+	closeText string // websocket close reason
+	reasonMu  sync.Mutex
+
+	ack   *acks
+	codec protocol.Codec
+	json  JSON
+
+	buckets   map[string]*tokenBucket // rate limiting buckets of incoming events
+	bucketsMu sync.Mutex
 
-	server  *Server
-	address string
-	header  http.Header
+	server    *Server
+	namespace string // rooms are scoped by the namespace
+	user      string // application user id, guarded by the server usersMu
+	address   string
+	header    http.Header
+	query     url.Values
+	remoteIP  string
+
+	logger logging.Logger
 }
 
 // init the Channel
 func (c *Channel) init() {
-	c.outC, c.stubC, c.upgradedC = make(chan string, queueBufferSize), make(chan string), make(chan string)
-	c.ack = &acks{}
+	if c.namespace == "" {
+		c.namespace = DefaultNamespace
+	}
+	switch {
+	case c.outBufferSize <= 0:
+		c.outBufferSize = queueBufferSize
+	case c.outBufferSize < minOutBufferSize:
+		c.outBufferSize = minOutBufferSize
+	}
+	c.outC, c.stubC, c.upgradedC = make(chan string, c.outBufferSize), make(chan string), make(chan string)
+	c.closedC, c.receivedC = make(chan struct{}), make(chan struct{}, 1)
+	c.binaryC = make(chan [][]byte, c.outBufferSize)
+	c.outStopC, c.outDoneC = make(chan struct{}), make(chan struct{})
+	if c.eventsQueueSize > 0 {
+		c.eventsC = make(chan *protocol.Message, c.eventsQueueSize)
+	}
+	c.ack = &acks{timeout: c.ackTimeout}
 	c.ack.ackC = make(map[int]chan string)
-	c.alive = true
+	c.buckets = make(map[string]*tokenBucket)
+	c.alive, c.state = true, StateConnecting
+	if c.codec == nil {
+		c.codec = protocol.JSONCodec{}
+	}
+	if c.json == nil {
+		c.json = StdJSON{}
+	}
 }
 
+// Namespace returns the namespace of the channel, the rooms it joins are scoped by it
+func (c *Channel) Namespace() string { return c.namespace }
+
 // Id returns an ID of the current socket connection
-func (c *Channel) Id() string { return c.connHeader.Sid }
+func (c *Channel) Id() string {
+	c.aliveMu.Lock()
+	defer c.aliveMu.Unlock()
+	return c.connHeader.Sid
+}
 
 // IsAlive checks that Channel is still alive
 func (c *Channel) IsAlive() bool {
@@ -68,8 +210,122 @@ func (c *Channel) IsAlive() bool {
 	return c.alive
 }
 
-// Close the client (Channel) connection
-func (c *Channel) Close() error { return c.close(c.server.event) }
+// pingParams returns the ping interval and timeout of the channel
+func (c *Channel) pingParams() (interval, timeout time.Duration) {
+	interval, timeout = c.conn.PingParams()
+	if c.pingInterval > 0 {
+		interval = c.pingInterval
+	}
+	if c.pingTimeout > 0 {
+		timeout = c.pingTimeout
+	}
+	return interval, timeout
+}
+
+// upgradedChannel returns the channel replacing this one after the transport upgrade, or nil
+func (c *Channel) upgradedChannel() *Channel {
+	c.upgradedMu.Lock()
+	defer c.upgradedMu.Unlock()
+	return c.upgraded
+}
+
+// Close the client (Channel) connection gracefully, it doesn't wait for the pending messages. The socket.io disconnect
+// and the engine.io close packets are sent in background after them, then the connection is closed and OnDisconnection
+// handler fires. The connection is closed anyway if they aren't sent within the ping timeout
+func (c *Channel) Close() error { return c.disconnect(DisconnectReasonServer) }
+
+// CloseWithReason closes the channel gracefully like Close, the websocket connection is closed with the given
+// close code and reason, e.g. websocket.ClosePolicyViolation. They are ignored by the polling connection
+func (c *Channel) CloseWithReason(code int, reason string) error {
+	c.reasonMu.Lock()
+	c.closeCode, c.closeText = code, reason
+	c.reasonMu.Unlock()
+	return c.Close()
+}
+
+// disconnect the channel gracefully with the given reason, the pending messages are flushed in background
+func (c *Channel) disconnect(reason string) error {
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		return upgraded.disconnect(reason)
+	}
+	if c.server == nil {
+		return ErrorServerNotSet
+	}
+
+	c.setDisconnectReason(reason)
+
+	// close immediately if the disconnect packet can't be queued
+	if !c.enqueue(protocol.MessageDisconnect, nil, false) {
+		return c.close(c.server.event)
+	}
+
+	go func() {
+		_, timeout := c.pingParams()
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case <-c.closedC:
+		case <-timer.C:
+			c.close(c.server.event)
+		}
+	}()
+	return nil
+}
+
+// DisconnectReason returns the reason why the channel was disconnected, it's empty while the channel is alive
+func (c *Channel) DisconnectReason() string {
+	c.reasonMu.Lock()
+	defer c.reasonMu.Unlock()
+	return c.reason
+}
+
+// setDisconnectReason sets the disconnection reason, if it was not set before
+func (c *Channel) setDisconnectReason(reason string) {
+	c.reasonMu.Lock()
+	if c.reason == "" {
+		c.reason = reason
+	}
+	c.reasonMu.Unlock()
+}
+
+// closeWithReason closes the channel with the given disconnection reason, if it was not set before
+func (c *Channel) closeWithReason(e *event, reason string) error {
+	c.setDisconnectReason(reason)
+	return c.close(e)
+}
+
+// readErrorReason returns the disconnection reason for the error err of reading from the connection
+func (c *Channel) readErrorReason(err error) string {
+	switch {
+	case transport.IsClosedByPeer(err):
+		return c.peerCloseReason()
+	case transport.IsTimeout(err):
+		return DisconnectReasonPingTimeout
+	}
+	return DisconnectReasonTransportError
+}
+
+// peerCloseReason returns the disconnection reason when the other side closes the connection
+func (c *Channel) peerCloseReason() string {
+	if c.server == nil {
+		return DisconnectReasonServer
+	}
+	return DisconnectReasonClient
+}
+
+// closeConn closes the connection, the websocket one with the close code and reason set by CloseWithReason
+func (c *Channel) closeConn() {
+	c.reasonMu.Lock()
+	code, text := c.closeCode, c.closeText
+	c.reasonMu.Unlock()
+
+	if ws, ok := c.conn.(*transport.WebsocketConnection); ok && code != 0 {
+		ws.CloseWithReason(code, text)
+		return
+	}
+	c.conn.Close()
+}
 
 // stub closes the polling client (Channel) connection at socket.io upgrade
 func (c *Channel) stub() error { return c.close(nil) }
@@ -78,27 +334,33 @@ func (c *Channel) stub() error { return c.close(nil) }
 func (c *Channel) close(e *event) error {
 	switch c.conn.(type) {
 	case *transport.PollingConnection:
-		c.server.logger.Debug("Channel.close() type: PollingConnection")
+		c.logger.Debug("Channel.close() type: PollingConnection")
 	case *transport.WebsocketConnection:
-		c.server.logger.Debug("Channel.close() type: WebsocketConnection")
+		c.logger.Debug("Channel.close() type: WebsocketConnection")
 	}
 
 	c.aliveMu.Lock()
-	defer c.aliveMu.Unlock()
-
 	if !c.alive { // already closed
+		c.aliveMu.Unlock()
 		return nil
 	}
+	c.alive, c.state = false, StateClosed
+	close(c.closedC)
+	c.aliveMu.Unlock()
 
-	c.conn.Close()
-	c.alive = false
+	// writing the websocket close frame may take up to the send timeout, so aliveMu isn't held
+	c.closeConn()
 
 	// clean outloop
 	for len(c.outC) > 0 {
 		<-c.outC
 	}
+	for len(c.binaryC) > 0 {
+		<-c.binaryC
+	}
 
 	if e != nil { // close
+		c.stopCoalesced()
 		c.outC <- protocol.MessageClose
 		e.callHandler(c, OnDisconnection)
 	} else { // stub at transport upgrade
@@ -114,64 +376,119 @@ func (c *Channel) close(e *event) error {
 
 // inLoop is an incoming events loop
 func (c *Channel) inLoop(e *event) error {
+	if c.eventsC != nil {
+		go c.handleEvents(e)
+		defer close(c.eventsC)
+	}
+
 	for {
-		message, err := c.conn.GetMessage()
+		message, frame, err := c.readMessage()
 		if err != nil {
-			c.server.logger.Debug(fmt.Sprintf("Channel.inLoop(), c.conn.GetMessage() err: %v, message: %s", err, message))
-			return c.close(e)
+			c.logger.Debug(fmt.Sprintf("Channel.inLoop(), c.conn.GetMessage() err: %v, message: %s", err, message))
+			return c.closeWithReason(e, c.readErrorReason(err))
 		}
 
-		if message == transport.StopMessage {
-			c.server.logger.Debug("Channel.inLoop(): StopMessage")
+		select {
+		case c.receivedC <- struct{}{}:
+		default:
+		}
+
+		if frame == nil && message == transport.StopMessage {
+			c.logger.Debug("Channel.inLoop(): StopMessage")
 			return nil
 		}
 
-		decodedMessage, err := protocol.Decode(message)
+		var decodedMessage *protocol.Message
+		if frame != nil {
+			decodedMessage, err = c.decodeBinaryPacket(frame)
+		} else {
+			decodedMessage, err = c.codec.Decode(message)
+		}
 		if err != nil {
-			c.server.logger.Debug(fmt.Sprintf("Channel.inLoop() decoding err: %v, message: %s", err, message))
-			c.close(e)
+			c.logger.Debug(fmt.Sprintf("Channel.inLoop() decoding err: %v, message: %s", err, message))
+			c.closeWithReason(e, DisconnectReasonProtocolError)
 			return err
 		}
 
+		if len(decodedMessage.Attachments) > 0 {
+			if err := c.receiveAttachments(decodedMessage); err != nil {
+				c.logger.Debug(fmt.Sprintf("Channel.inLoop() attachments err: %v, message: %s", err, message))
+				c.closeWithReason(e, DisconnectReasonProtocolError)
+				return err
+			}
+		}
+
 		switch decodedMessage.Type {
 		case protocol.MessageTypeOpen:
-			c.server.logger.Debug(fmt.Sprintf("Channel.inLoop(), protocol.MessageTypeOpen, decodedMessage: %+v", decodedMessage))
-			if err := json.Unmarshal([]byte(decodedMessage.Source[1:]), &c.connHeader); err != nil {
-				c.close(e)
+			if logging.DebugEnabled(c.logger) {
+				c.logger.Debug(fmt.Sprintf("Channel.inLoop(), protocol.MessageTypeOpen, decodedMessage: %+v", decodedMessage))
 			}
+			var header ConnectionHeader
+			if err := c.json.Unmarshal([]byte(decodedMessage.Source[1:]), &header); err != nil {
+				c.closeWithReason(e, DisconnectReasonProtocolError)
+			}
+			c.aliveMu.Lock()
+			c.connHeader = header
+			c.aliveMu.Unlock()
 			e.callHandler(c, OnConnection)
 
 		case protocol.MessageTypePing:
-			c.server.logger.Debug(fmt.Sprintf("Channel.inLoop(), protocol.MessageTypePing, decodedMessage: %+v", decodedMessage))
+			if logging.DebugEnabled(c.logger) {
+				c.logger.Debug(fmt.Sprintf("Channel.inLoop(), protocol.MessageTypePing, decodedMessage: %+v", decodedMessage))
+			}
 			if decodedMessage.Source == protocol.MessagePingProbe {
-				c.server.logger.Debug(fmt.Sprintf("Channel.inLoop(), decodedMessage.Source: %s", decodedMessage.Source))
+				if logging.DebugEnabled(c.logger) {
+					c.logger.Debug(fmt.Sprintf("Channel.inLoop(), decodedMessage.Source: %s", decodedMessage.Source))
+				}
 				c.outC <- protocol.MessagePongProbe
 				c.upgradedC <- transport.UpgradedMessage
 			} else {
 				c.outC <- protocol.MessagePong
 			}
 
+		case protocol.MessageTypeEmpty:
+			if c.server != nil {
+				c.server.acceptConnect(c, decodedMessage)
+			}
+
+		case protocol.MessageTypeClose:
+			c.logger.Debug("Channel.inLoop(), the other side closed the connection")
+			return c.closeWithReason(e, c.peerCloseReason())
+
 		case protocol.MessageTypeUpgrade:
 		case protocol.MessageTypeBlank:
 		case protocol.MessageTypePong:
 		default:
-			go e.processIncoming(c, decodedMessage)
+			c.touch()
+			// the rate limit is checked before the handler goroutine is spawned
+			if !c.allowIncoming(decodedMessage) {
+				continue
+			}
+			switch {
+			case e.isInline(decodedMessage):
+				e.processIncoming(c, decodedMessage)
+			case c.isOrdered(decodedMessage):
+				c.eventsC <- decodedMessage
+			default:
+				go e.processIncoming(c, decodedMessage)
+			}
 		}
 	}
-
-	return nil
 }
 
 // outLoop is an outgoing events loop, sends messages from channel to socket
 func (c *Channel) outLoop(e *event) error {
+	defer close(c.outDoneC)
 	for {
 		outBufferLen := len(c.outC)
-		c.server.logger.Debug("Channel.outLoop(), outBufferLen:", zap.Int("outBufferLen", outBufferLen))
+		if logging.DebugEnabled(c.logger) {
+			c.logger.Debug("Channel.outLoop(), outBufferLen:", "outBufferLen", outBufferLen)
+		}
 		switch {
-		case outBufferLen >= queueBufferSize-1:
-			c.server.logger.Debug("Channel.outLoop(), outBufferLen >= queueBufferSize-1")
-			return c.close(e)
-		case outBufferLen > int(queueBufferSize/2):
+		case outBufferLen >= c.outBufferSize-1:
+			c.logger.Debug("Channel.outLoop(), outBufferLen >= c.outBufferSize-1")
+			return c.closeWithReason(e, DisconnectReasonOverflow)
+		case outBufferLen > c.outBufferSize/2:
 			overfloodedMu.Lock()
 			overflooded[c] = struct{}{}
 			overfloodedMu.Unlock()
@@ -181,24 +498,127 @@ func (c *Channel) outLoop(e *event) error {
 			overfloodedMu.Unlock()
 		}
 
-		m := <-c.outC
+		var m string
+		select {
+		case m = <-c.outC:
+		case <-c.outStopC:
+			return nil
+		}
 
 		if m == protocol.MessageClose || m == protocol.MessageStub {
 			return nil
 		}
 
-		if err := c.conn.WriteMessage(m); err != nil {
-			c.server.logger.Warn("Channel.outLoop(), failed to c.conn.WriteMessage() with err:", zap.Error(err))
+		m, compress := splitCompress(m)
+		if packet, binary := splitBinary(m); binary {
+			if err := c.conn.WriteBinary(protocol.EncodeAttachment([]byte(packet))); err != nil {
+				c.logger.Warn("Channel.outLoop(), failed to c.conn.WriteBinary() with err:", "err", err)
+				return c.closeWithReason(e, DisconnectReasonTransportError)
+			}
+			c.touch()
+			continue
+		}
+		if err := c.writeMessage(m, compress); err != nil {
+			c.logger.Warn("Channel.outLoop(), failed to c.conn.WriteMessage() with err:", "err", err)
+			return c.closeWithReason(e, DisconnectReasonTransportError)
+		}
+		if protocol.IsEvent(m) {
+			c.touch()
+		}
+
+		if protocol.IsBinary(m) {
+			for _, attachment := range <-c.binaryC {
+				if err := c.conn.WriteBinary(protocol.EncodeAttachment(attachment)); err != nil {
+					c.logger.Warn("Channel.outLoop(), failed to c.conn.WriteBinary() with err:", "err", err)
+					return c.closeWithReason(e, DisconnectReasonTransportError)
+				}
+			}
+		}
+
+		// the disconnect packet is the last one to be sent before closing the channel
+		if m == protocol.MessageDisconnect {
+			if err := c.conn.WriteMessage(protocol.MessageClose); err != nil {
+				c.logger.Debug("Channel.outLoop(), failed to write close message with err:", "err", err)
+			}
 			return c.close(e)
 		}
 	}
-	return nil
+}
+
+// receiveAttachments reads binary attachments of the message m from the connection
+// and substitutes them into the message args
+func (c *Channel) receiveAttachments(m *protocol.Message) error {
+	for i := range m.Attachments {
+		frame, err := c.conn.GetBinary()
+		if err != nil {
+			return err
+		}
+
+		if m.Attachments[i], err = protocol.DecodeAttachment(frame); err != nil {
+			return err
+		}
+	}
+
+	return protocol.FillPlaceholders(m)
+}
+
+// heartbeatLoop sends ping messages at the ping interval and closes the channel if nothing
+// was received from the other side within the ping timeout after the ping
+func (c *Channel) heartbeatLoop(e *event) {
+	interval, timeout := c.pingParams()
+	for {
+		select {
+		case <-c.closedC:
+			return
+		case <-time.After(interval):
+		}
+
+		// only messages received after the ping are taken into account
+		select {
+		case <-c.receivedC:
+		default:
+		}
+
+		c.enqueue(protocol.MessagePing, nil, false)
+
+		select {
+		case <-c.closedC:
+			return
+		case <-c.receivedC:
+		case <-time.After(timeout):
+			c.logger.Debug("Channel.heartbeatLoop(), ping timeout")
+			c.closeWithReason(e, DisconnectReasonPingTimeout)
+			return
+		}
+	}
+}
+
+// touch marks the channel as active now
+func (c *Channel) touch() { atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano()) }
+
+// idleLoop disconnects the channel if no event is sent or received within the idle timeout
+func (c *Channel) idleLoop() {
+	c.touch()
+	for {
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&c.lastActivity)))
+		if idle >= c.idleTimeout {
+			c.logger.Debug("Channel.idleLoop(), idle timeout", "sid", c.Id())
+			c.disconnect(DisconnectReasonIdle)
+			return
+		}
+
+		select {
+		case <-c.closedC:
+			return
+		case <-time.After(c.idleTimeout - idle):
+		}
+	}
 }
 
 // pingLoop sends ping messages for keeping connection alive
 func (c *Channel) pingLoop() {
 	for {
-		interval, _ := c.conn.PingParams()
+		interval, _ := c.pingParams()
 		time.Sleep(interval)
 		if !c.IsAlive() {
 			return
@@ -208,60 +628,300 @@ func (c *Channel) pingLoop() {
 	}
 }
 
-// send message packet to the given channel c with payload
-func (c *Channel) send(m *protocol.Message, payload interface{}) error {
+// encode message packet m with payload into the protocol format.
+// Several payload values are encoded as several message args
+func (c *Channel) encode(m *protocol.Message, payloads ...interface{}) (command string, err error) {
 	// preventing encoding/json "index out of range" panic
 	defer func() {
 		if r := recover(); r != nil {
-			c.server.logger.Warn("Channel.send(): recovered from panic:", zap.Any("r", r))
+			c.logger.Warn("Channel.encode(): recovered from panic:", "r", r)
+			err = fmt.Errorf("recovered from panic: %v", r)
 		}
 	}()
 
+	// binary values travel as attachments if the transport supports it, unless the codec encodes them
+	if c.supportsBinary() && !c.encodesBinary() {
+		payloads = append([]interface{}(nil), payloads...)
+		for i := range payloads {
+			payloads[i], m.Attachments = protocol.ExtractAttachments(payloads[i], m.Attachments)
+		}
+	}
+
+	if codec, ok := c.codec.(protocol.ArgsCodec); ok {
+		// nil payload means no args, as with JSON
+		if len(payloads) == 1 && payloads[0] == nil {
+			payloads = nil
+		}
+		if m.Args, err = codec.MarshalArgs(payloads); err != nil {
+			return "", err
+		}
+		return c.encodePacket(m)
+	}
+
+	if len(payloads) > 1 {
+		args := make([]string, len(payloads))
+		for i := range payloads {
+			b, err := c.json.Marshal(&payloads[i])
+			if err != nil {
+				return "", err
+			}
+			args[i] = string(b)
+		}
+		m.Args = strings.Join(args, ",")
+		return c.encodePacket(m)
+	}
+
+	var payload interface{}
+	if len(payloads) == 1 {
+		payload = payloads[0]
+	}
+
 	if payload != nil {
-		b, err := json.Marshal(&payload)
+		b, err := c.json.Marshal(&payload)
 		if err != nil {
-			return err
+			return "", err
 		}
 		m.Args = string(b)
 	}
 
-	command, err := protocol.Encode(m)
+	return c.encodePacket(m)
+}
+
+// supportsBinary checks that the channel connection is able to send binary messages
+func (c *Channel) supportsBinary() bool {
+	switch conn := c.conn.(type) {
+	case *transport.WebsocketConnection:
+		return true
+	case *transport.PollingConnection:
+		return conn.SupportsBinary()
+	}
+	return false
+}
+
+// enqueue the encoded command with its attachments to the outgoing buffer.
+// If block is false and the buffer is full, the command is dropped and false is returned.
+// Otherwise it waits for the place in the buffer up to sendTimeout
+func (c *Channel) enqueue(command string, attachments [][]byte, block bool) bool {
+	if len(attachments) > 0 {
+		// keep binary packets and their attachments in the same order
+		c.binaryMu.Lock()
+		defer c.binaryMu.Unlock()
+	}
+
+	if c.maxOutboxSize > 0 {
+		if !c.enqueueLimited(command, block) {
+			return false
+		}
+	} else if block && c.sendTimeout > 0 {
+		timer := time.NewTimer(c.sendTimeout)
+		defer timer.Stop()
+
+		select {
+		case c.outC <- command:
+		case <-timer.C:
+			return false
+		}
+	} else if block {
+		c.outC <- command
+	} else {
+		select {
+		case c.outC <- command:
+		default:
+			return false
+		}
+	}
+
+	if len(attachments) > 0 {
+		c.binaryC <- attachments
+	}
+	return true
+}
+
+// enqueueLimited queues the command to the outgoing buffer unless the channel outbox exceeds maxOutboxSize,
+// the sender isn't blocked. If block is false the command is dropped then, otherwise the channel is closed
+// as a slow consumer. False is returned if the command isn't queued
+func (c *Channel) enqueueLimited(command string, block bool) bool {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+
+	if c.outboxLen() < c.maxOutboxSize {
+		select {
+		case c.outC <- command:
+			return true
+		default:
+		}
+	}
+	if !block {
+		return false
+	}
+
+	c.logger.Warn("Channel.enqueueLimited() outbox exceeds the limit, closing the channel:", "sid", c.Id(), "maxOutboxSize", c.maxOutboxSize)
+	if c.server != nil {
+		go c.closeWithReason(c.server.event, DisconnectReasonSlow)
+	}
+	return false
+}
+
+// send message packet to the given channel c with payload, several payload values are sent as several args
+func (c *Channel) send(m *protocol.Message, payloads ...interface{}) error {
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		return upgraded.send(m, payloads...)
+	}
+	if !c.IsAlive() {
+		return ErrorChannelClosed
+	}
+
+	command, err := c.encode(m, payloads...)
 	if err != nil {
 		return err
 	}
 
-	if len(c.outC) == queueBufferSize {
-		return ErrorSocketOverflood
+	if !c.enqueue(command, m.Attachments, true) {
+		return ErrorSendQueueTimeout
 	}
+	c.metrics().OnMessageOut(c, m.EventName)
+	return nil
+}
 
-	c.outC <- command
+// SendRaw queues the pre-formed engine.io packet to be sent as is, e.g. "6" or "42[\"event\"]", bypassing
+// the event encoding. It's an advanced API intended for the protocol debugging: the packet isn't validated,
+// so a malformed one can break the client connection
+func (c *Channel) SendRaw(packet string) error {
+	if protocol.IsBinary(packet) || packet == protocol.MessageClose || packet == protocol.MessageStub {
+		return ErrorRawPacketNotAllowed
+	}
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		return upgraded.SendRaw(packet)
+	}
+	if !c.IsAlive() {
+		return ErrorChannelClosed
+	}
+
+	if !c.enqueue(packet, nil, true) {
+		return ErrorSendQueueTimeout
+	}
 	return nil
 }
 
-// Emit an asynchronous event with the given name and payload
+// Emit an asynchronous event with the given name and payload. Payload encoding errors are returned,
+// as well as ErrorChannelClosed and ErrorSendQueueTimeout if the event can't be queued to be sent
 func (c *Channel) Emit(name string, payload interface{}) error {
 	message := &protocol.Message{Type: protocol.MessageTypeEmit, EventName: name}
 	return c.send(message, payload)
 }
 
-// Ack a synchronous event with the given name and payload and wait for/receive the response
-func (c *Channel) Ack(name string, payload interface{}, timeout time.Duration) (string, error) {
+// Reply sends the payload back to the channel in response to the incoming message m, it's intended to be called
+// by the handler accepting the whole message. If m requested an ack, the payload is sent as the ack response,
+// it's an alternative to returning the values from the handler, so the handler shouldn't do both. Otherwise
+// the payload is emitted as an event with the name of m, so the client doesn't need to request an ack
+func (c *Channel) Reply(m *protocol.Message, payload interface{}) error {
+	if m.Type == protocol.MessageTypeAckRequest {
+		return c.send(&protocol.Message{Type: protocol.MessageTypeAckResponse, AckID: m.AckID}, payload)
+	}
+	return c.Emit(m.EventName, payload)
+}
+
+// EmitVolatile emits an asynchronous event with the given name and payload without blocking.
+// If the outgoing buffer is full or the outbox exceeds its maximum size, the message is dropped
+// and ErrorMessageDropped is returned, the channel isn't closed as a slow consumer
+func (c *Channel) EmitVolatile(name string, payload interface{}) error {
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		return upgraded.EmitVolatile(name, payload)
+	}
+	if !c.IsAlive() {
+		return ErrorChannelClosed
+	}
+
+	m := &protocol.Message{Type: protocol.MessageTypeEmit, EventName: name}
+	command, err := c.encode(m, payload)
+	if err != nil {
+		return err
+	}
+
+	if !c.enqueue(command, m.Attachments, false) {
+		return ErrorMessageDropped
+	}
+	c.metrics().OnMessageOut(c, m.EventName)
+	return nil
+}
+
+// EmitAndWait emits a synchronous event with the given name and payload and waits for the ack response.
+// ErrorAckTimeout is returned if the response didn't arrive within the timeout
+func (c *Channel) EmitAndWait(name string, payload interface{}, timeout time.Duration) (string, error) {
 	m := &protocol.Message{Type: protocol.MessageTypeAckRequest, AckID: c.ack.nextId(), EventName: name}
 
-	ackC := make(chan string)
+	// buffered, so a late response doesn't block the incoming message processing
+	ackC := make(chan string, 1)
 	c.ack.register(m.AckID, ackC)
+	defer c.ack.unregister(m.AckID)
 
+	start := time.Now()
 	if err := c.send(m, payload); err != nil {
-		c.ack.unregister(m.AckID)
+		return "", err
 	}
 
 	select {
 	case result := <-ackC:
+		c.metrics().OnAckLatency(time.Since(start))
 		return result, nil
 	case <-time.After(timeout):
-		c.ack.unregister(m.AckID)
+		return "", ErrorAckTimeout
+	}
+}
+
+// EmitWithAck emits an event with the given name and payload requesting an ack, the callback cb is called
+// with the ack response in its own goroutine, so it may block, e.g. calling EmitAndWait. If the response
+// doesn't arrive within the ack timeout (see Server.SetAckTimeout), cb is called with ErrorAckTimeout
+func (c *Channel) EmitWithAck(name string, payload interface{}, cb func(data string, err error)) error {
+	m := &protocol.Message{Type: protocol.MessageTypeAckRequest, AckID: c.ack.nextId(), EventName: name}
+
+	start := time.Now()
+	timeout := c.ack.timeout
+	if timeout <= 0 {
+		_, timeout = c.pingParams()
+	}
+	c.ack.registerCallback(m.AckID, func(data string, err error) {
+		if err == nil {
+			c.metrics().OnAckLatency(time.Since(start))
+		}
+		cb(data, err)
+	}, timeout)
+
+	if err := c.send(m, payload); err != nil {
+		c.ack.takeCallback(m.AckID)
+		return err
+	}
+	return nil
+}
+
+// PendingAcks returns the amount of the acks requested by the channel and waiting for the response
+func (c *Channel) PendingAcks() int { return c.ack.pending() }
+
+// OutboxLen returns the amount of the messages queued to be sent to the channel and not written yet,
+// including the ones queued by the polling transport. The growing amount means a slow client
+func (c *Channel) OutboxLen() int {
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		return upgraded.OutboxLen()
+	}
+	return c.outboxLen()
+}
+
+// outboxLen returns the amount of the messages queued to the channel and to its polling connection
+func (c *Channel) outboxLen() int {
+	if polling, ok := c.conn.(*transport.PollingConnection); ok {
+		return len(c.outC) + polling.QueueLen()
+	}
+	return len(c.outC)
+}
+
+// Ack a synchronous event with the given name and payload and wait for/receive the response.
+// It acts like EmitAndWait but returns ErrorSendTimeout on timeout
+func (c *Channel) Ack(name string, payload interface{}, timeout time.Duration) (string, error) {
+	result, err := c.EmitAndWait(name, payload, timeout)
+	if err == ErrorAckTimeout {
 		return "", ErrorSendTimeout
 	}
+	return result, err
 }
 
 // IP returns an IP of the socket client
@@ -273,51 +933,106 @@ func (c *Channel) IP() string {
 	return c.address
 }
 
-// RequestHeader returns a connection request connectionHeader
+// RemoteAddr returns the IP address of the client. X-Forwarded-For and X-Real-IP headers are respected
+// only for the connections from the proxies trusted with Server.SetTrustedProxies
+func (c *Channel) RemoteAddr() string { return c.remoteIP }
+
+// Subprotocol returns the websocket subprotocol negotiated with the client, it's empty for other transports
+func (c *Channel) Subprotocol() string {
+	if ws, ok := c.conn.(*transport.WebsocketConnection); ok {
+		return ws.Subprotocol()
+	}
+	return ""
+}
+
+// HandshakeResponse returns the server response to the websocket upgrade request of the client channel,
+// e.g. to read its headers. It's nil for the channels of the server and the other transports
+func (c *Channel) HandshakeResponse() *http.Response {
+	if ws, ok := c.conn.(*transport.WebsocketConnection); ok {
+		return ws.HandshakeResponse()
+	}
+	return nil
+}
+
+// SetTimeouts sets the maximum time to wait for the incoming message and for the outgoing message to be written
+// by the current transport connection of the channel, e.g. a strict send timeout fails fast on a stuck client.
+// Zero leaves the transport timeout
+func (c *Channel) SetTimeouts(receive, send time.Duration) {
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		upgraded.SetTimeouts(receive, send)
+		return
+	}
+	if conn, ok := c.conn.(transport.TimeoutSetter); ok {
+		conn.SetReceiveTimeout(receive)
+		conn.SetSendTimeout(send)
+	}
+}
+
+// RequestHeader returns a connection request header
 func (c *Channel) RequestHeader() http.Header { return c.header }
 
-// Join this channel to the given room
-func (c *Channel) Join(room string) error {
+// Query returns the query parameters of the connection request URL
+func (c *Channel) Query() url.Values { return c.query }
+
+// Join this channel to the given room, it returns false if the channel is already joined to it
+func (c *Channel) Join(room string) (bool, error) {
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		return upgraded.Join(room)
+	}
 	if c.server == nil {
-		return ErrorServerNotSet
+		return false, ErrorServerNotSet
 	}
 
-	c.server.channelsMu.Lock()
-	defer c.server.channelsMu.Unlock()
-
-	if _, ok := c.server.channels[room]; !ok {
-		c.server.channels[room] = make(map[*Channel]struct{})
+	joined := c.server.adapter.Join(c, room)
+	if onRoomJoin, _ := c.server.roomHooks(); joined && onRoomJoin != nil {
+		onRoomJoin(c, room)
 	}
+	return joined, nil
+}
 
-	if _, ok := c.server.rooms[c]; !ok {
-		c.server.rooms[c] = make(map[string]struct{})
+// Leave the given room (remove channel from it), it returns false if the channel is not joined to it
+func (c *Channel) Leave(room string) (bool, error) {
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		return upgraded.Leave(room)
+	}
+	if c.server == nil {
+		return false, ErrorServerNotSet
 	}
 
-	c.server.channels[room][c], c.server.rooms[c][room] = struct{}{}, struct{}{}
-	return nil
+	left := c.server.adapter.Leave(c, room)
+	if _, onRoomLeave := c.server.roomHooks(); left && onRoomLeave != nil {
+		onRoomLeave(c, room)
+	}
+	return left, nil
 }
 
-// Leave the given room (remove channel from it)
-func (c *Channel) Leave(room string) error {
+// LeaveAll rooms this channel is joined to
+func (c *Channel) LeaveAll() error {
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		return upgraded.LeaveAll()
+	}
 	if c.server == nil {
 		return ErrorServerNotSet
 	}
 
-	c.server.channelsMu.Lock()
-	defer c.server.channelsMu.Unlock()
-
-	if _, ok := c.server.channels[room]; ok {
-		delete(c.server.channels[room], c)
-		if len(c.server.channels[room]) == 0 {
-			delete(c.server.channels, room)
+	rooms := c.server.adapter.LeaveAll(c)
+	if _, onRoomLeave := c.server.roomHooks(); onRoomLeave != nil {
+		for _, room := range rooms {
+			onRoomLeave(c, room)
 		}
 	}
+	return nil
+}
 
-	if _, ok := c.server.rooms[c]; ok {
-		delete(c.server.rooms[c], room)
+// Rooms returns names of the rooms this channel is joined to
+func (c *Channel) Rooms() []string {
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		return upgraded.Rooms()
 	}
-
-	return nil
+	if c.server == nil {
+		return []string{}
+	}
+	return c.server.adapter.Rooms(c)
 }
 
 // Amount returns an amount of channels joined to the given room, using channel
@@ -325,7 +1040,7 @@ func (c *Channel) Amount(room string) int {
 	if c.server == nil {
 		return 0
 	}
-	return c.server.Amount(room)
+	return c.server.AmountIn(c.namespace, room)
 }
 
 // List returns a list of channels joined to the given room, using channel
@@ -333,7 +1048,7 @@ func (c *Channel) List(room string) []*Channel {
 	if c.server == nil {
 		return []*Channel{}
 	}
-	return c.server.List(room)
+	return c.server.ListIn(c.namespace, room)
 }
 
 // BroadcastTo the the given room an event with given name and payload, using channel
@@ -341,5 +1056,14 @@ func (c *Channel) BroadcastTo(room, name string, payload interface{}) {
 	if c.server == nil {
 		return
 	}
-	c.server.BroadcastTo(room, name, payload)
+	c.server.BroadcastToIn(c.namespace, room, name, payload)
+}
+
+// BroadcastToExcludingSelf broadcasts to the given room an event with given name and payload,
+// skipping this channel, using channel
+func (c *Channel) BroadcastToExcludingSelf(room, name string, payload interface{}) {
+	if c.server == nil {
+		return
+	}
+	c.server.BroadcastToExcept(c, room, name, payload)
 }
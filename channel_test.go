@@ -0,0 +1,231 @@
+package socketio
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vanti-dev/golang-socketio/logging"
+	"github.com/vanti-dev/golang-socketio/transport"
+)
+
+// fakeConn is a transport.Connection recording the written messages, nothing is received until it's closed
+type fakeConn struct {
+	written []string
+	closedC chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+}
+
+func newFakeConn() *fakeConn { return &fakeConn{closedC: make(chan struct{})} }
+
+func (f *fakeConn) GetMessage() (string, error) {
+	<-f.closedC
+	return "", transport.ErrClosed
+}
+
+func (f *fakeConn) WriteMessage(message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.written = append(f.written, message)
+	return nil
+}
+
+func (f *fakeConn) GetBinary() ([]byte, error) { return nil, errors.New("not supported") }
+func (f *fakeConn) WriteBinary([]byte) error   { return errors.New("not supported") }
+
+func (f *fakeConn) Close() error {
+	f.once.Do(func() { close(f.closedC) })
+	return nil
+}
+
+func (f *fakeConn) PingParams() (time.Duration, time.Duration) { return time.Minute, time.Minute }
+
+// newTestChannel returns the channel of the server s with the fake connection, its loops aren't started
+func newTestChannel(s *Server, sid string) *Channel {
+	c := &Channel{conn: newFakeConn(), server: s, connHeader: ConnectionHeader{Sid: sid}, logger: logging.Nop(),
+		outBufferSize: s.outBufferSize, maxOutboxSize: s.maxOutboxSize}
+	c.init()
+	return c
+}
+
+func TestEmitVolatileDropsAtOutboxLimit(t *testing.T) {
+	s := NewServer(nil, nil, nil)
+	s.SetMaxOutboxSize(2)
+	c := newTestChannel(s, "sid")
+
+	for i := 0; i < 2; i++ {
+		if err := c.EmitVolatile("event", i); err != nil {
+			t.Fatalf("EmitVolatile() #%d = %v, want nil", i, err)
+		}
+	}
+	if err := c.EmitVolatile("event", 2); err != ErrorMessageDropped {
+		t.Fatalf("EmitVolatile() over the limit = %v, want ErrorMessageDropped", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if !c.IsAlive() {
+		t.Fatalf("channel closed with reason %q, want it alive", c.DisconnectReason())
+	}
+}
+
+func TestEmitClosesSlowConsumer(t *testing.T) {
+	s := NewServer(nil, nil, nil)
+	s.SetMaxOutboxSize(1)
+	c := newTestChannel(s, "sid")
+
+	if err := c.Emit("event", 1); err != nil {
+		t.Fatalf("Emit() = %v, want nil", err)
+	}
+	if err := c.Emit("event", 2); err != ErrorSendQueueTimeout {
+		t.Fatalf("Emit() over the limit = %v, want ErrorSendQueueTimeout", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for c.IsAlive() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reason := c.DisconnectReason(); reason != DisconnectReasonSlow {
+		t.Fatalf("DisconnectReason() = %q, want %q", reason, DisconnectReasonSlow)
+	}
+}
+
+// openPolling opens the engine.io v3 polling session with the server at url and returns its sid
+func openPolling(t *testing.T, url string) string {
+	t.Helper()
+	return openPollingQuery(t, url, "EIO=3&transport=polling")
+}
+
+// openPollingQuery opens the polling session with the server at url requested with the query and returns its sid
+func openPollingQuery(t *testing.T, url, query string) string {
+	t.Helper()
+	resp, err := http.Get(url + DefaultPath + "?" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header ConnectionHeader
+	start := strings.Index(string(body), "{")
+	if start < 0 {
+		t.Fatalf("no open packet in the handshake response %q", body)
+	}
+	if err := json.NewDecoder(strings.NewReader(string(body[start:]))).Decode(&header); err != nil {
+		t.Fatalf("can't decode the open packet of %q: %v", body, err)
+	}
+	return header.Sid
+}
+
+func TestOutboxLenCountsPollingQueue(t *testing.T) {
+	s := NewServer(nil, transport.DefaultPollingTransport(), nil)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c, err := s.GetChannel(openPolling(t, ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := c.Emit("event", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the messages are moved to the polling queue, as nobody polls
+	deadline := time.Now().Add(time.Second)
+	for len(c.outC) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := c.OutboxLen(); n != 5 {
+		t.Fatalf("OutboxLen() = %d, want 5", n)
+	}
+}
+
+func TestCloseDoesNotWaitForFlush(t *testing.T) {
+	s := NewServer(nil, nil, nil)
+	c := newTestChannel(s, "sid")
+
+	// nothing sends the queued disconnect packet, as the outgoing loop isn't running
+	done := make(chan error, 1)
+	go func() { done <- c.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Close() = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close() waits for the pending messages to be sent")
+	}
+
+	if !c.IsAlive() {
+		t.Fatal("the channel is closed before the disconnect packet is sent")
+	}
+	if reason := c.DisconnectReason(); reason != DisconnectReasonServer {
+		t.Fatalf("DisconnectReason() = %q, want %q", reason, DisconnectReasonServer)
+	}
+}
+
+// slowCloseConn is the fakeConn whose Close blocks until release is closed, like the websocket close frame write
+type slowCloseConn struct {
+	*fakeConn
+	release chan struct{}
+}
+
+func (f *slowCloseConn) Close() error {
+	<-f.release
+	return f.fakeConn.Close()
+}
+
+func TestCloseConnWithoutAliveLock(t *testing.T) {
+	s := NewServer(nil, nil, nil)
+	c := newTestChannel(s, "sid")
+	conn := &slowCloseConn{fakeConn: newFakeConn(), release: make(chan struct{})}
+	c.conn = conn
+	defer close(conn.release)
+
+	go c.close(s.event)
+
+	aliveC := make(chan bool, 1)
+	go func() {
+		for c.IsAlive() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		aliveC <- false
+	}()
+	select {
+	case <-aliveC:
+	case <-time.After(time.Second):
+		t.Fatal("IsAlive() is blocked or true while the connection is being closed")
+	}
+}
+
+func TestSmallOutBufferSize(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.SetOutBufferSize(1)
+	s.On("echo", func(c *Channel, v string) string { return v })
+
+	client := NewClient(nil)
+	if err := client.Dial(websocketURL(ts), transport.DefaultWebsocketTransport()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.EmitAndWait("echo", "v", time.Second); err != nil {
+			t.Fatalf("EmitAndWait() #%d = %v", i, err)
+		}
+	}
+	if c := newTestChannel(s, "sid"); cap(c.outC) != minOutBufferSize {
+		t.Fatalf("outgoing queue size = %d, want %d", cap(c.outC), minOutBufferSize)
+	}
+}
@@ -1,28 +1,40 @@
 package socketio
 
 import (
-	"go.uber.org/zap"
+	"errors"
+	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
-	_ "time"
-
+	"github.com/vanti-dev/golang-socketio/logging"
 	"github.com/vanti-dev/golang-socketio/transport"
 )
 
 const (
 	webSocketSchema       = "ws://"
 	webSocketSecureSchema = "wss://"
-	socketioWebsocketURL  = "/socket.io/?EIO=3&transport=websocket"
+	socketioWebsocketURL  = DefaultPath + "?EIO=3&transport=websocket"
 
 	pollingSchema       = "http://"
 	pollingSecureSchema = "https://"
-	socketioPollingURL  = "/socket.io/?EIO=3&transport=polling"
+	socketioPollingURL  = DefaultPath + "?EIO=3&transport=polling"
 )
 
-// Client represents socket.io client
+// ErrorClientConnected is returned by Client.Dial if the client is already connected
+var ErrorClientConnected = errors.New("client is already connected")
+
+// Client represents socket.io client. The handlers are registered with On like the server ones,
+// including the OnConnection and OnDisconnection ones, the events are sent with the Client methods.
+// The values returned by the handler are sent as the ack response to the ack request of the server.
+// The methods are safe to call before the client is connected and concurrently with Dial
 type Client struct {
 	*event
-	*Channel
+
+	channel    *Channel      // the channel of the current connection, nil if the client has never been connected
+	ackTimeout time.Duration // ack response timeout of EmitWithAck, the transport ping timeout is used if zero
+	channelMu  sync.RWMutex
+	dialMu     sync.Mutex // serializes the connection attempts
 }
 
 // AddrWebsocket returns an url for socket.io connection for websocket transport
@@ -46,33 +58,239 @@ func AddrPolling(host string, port int, secure bool) string {
 // Dial connects to server and initializes socket.io protocol
 // The correct ws protocol addr example:
 // ws://myserver.com/socket.io/?EIO=3&transport=websocket
-func Dial(addr string, tr transport.Transport, logger *zap.Logger) (*Client, error) {
-	c := &Client{
-		Channel: &Channel{},
-		event: &event{
-			logger: logger,
-		},
+//
+// The events received before the handlers are registered on the returned client are missed, including
+// the OnConnection one, use NewClient and Client.Dial to register them before connecting
+func Dial(addr string, tr transport.Transport, logger logging.Logger) (*Client, error) {
+	return dial(addr, tr, newClientEvent(logger))
+}
+
+// NewClient returns a client, handlers should be registered on it before Dial. Its emitting methods
+// return ErrorNotConnected until it's connected
+func NewClient(logger logging.Logger) *Client { return &Client{event: newClientEvent(logger)} }
+
+// newClientEvent returns the events mapping of a client
+func newClientEvent(logger logging.Logger) *event {
+	e := &event{logger: logging.OrNop(logger)}
+	e.init()
+	return e
+}
+
+// Dial connects the client to server with the given url using the transport tr, see Dial. The client
+// disconnected before may be connected again, the channel of the previous connection is replaced
+func (c *Client) Dial(addr string, tr transport.Transport) error {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+
+	if c.IsAlive() {
+		return ErrorClientConnected
 	}
-	c.Channel.init()
-	c.event.init()
+	return c.connect(addr, tr)
+}
+
+// dial connects to server using the given events mapping
+func dial(addr string, tr transport.Transport, e *event) (*Client, error) {
+	c := &Client{event: e}
+	if err := c.connect(addr, tr); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect connects the client to server and starts the loops of its new channel
+func (c *Client) connect(addr string, tr transport.Transport) error {
+	c.channelMu.RLock()
+	channel := &Channel{logger: c.event.logger, ackTimeout: c.ackTimeout}
+	c.channelMu.RUnlock()
+	channel.init()
 
 	var err error
-	c.conn, err = tr.Connect(addr)
+	channel.conn, err = tr.Connect(addr)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	channel.setState(StateConnected)
+
+	c.channelMu.Lock()
+	c.channel = channel
+	c.channelMu.Unlock()
 
-	go c.Channel.inLoop(c.event)
-	go c.Channel.outLoop(c.event)
-	go c.Channel.pingLoop()
+	go channel.inLoop(c.event)
+	go channel.outLoop(c.event)
+	go channel.pingLoop()
 
 	switch tr.(type) {
 	case *transport.PollingClientTransport:
-		go c.event.callHandler(c.Channel, OnConnection)
+		go c.event.callHandler(channel, OnConnection)
 	}
 
-	return c, nil
+	return nil
+}
+
+// Channel returns the channel of the current connection, or nil if the client has never been connected
+func (c *Client) Channel() *Channel {
+	c.channelMu.RLock()
+	defer c.channelMu.RUnlock()
+	return c.channel
+}
+
+// Id returns an ID of the current connection, it's empty if the client has never been connected
+func (c *Client) Id() string {
+	channel := c.Channel()
+	if channel == nil {
+		return ""
+	}
+	return channel.Id()
+}
+
+// IsAlive checks that the client is connected
+func (c *Client) IsAlive() bool {
+	channel := c.Channel()
+	return channel != nil && channel.IsAlive()
+}
+
+// State returns the state of the current connection, it's StateClosed if the client has never been connected
+func (c *Client) State() ConnectionState {
+	channel := c.Channel()
+	if channel == nil {
+		return StateClosed
+	}
+	return channel.State()
+}
+
+// DisconnectReason returns the reason why the current connection was disconnected, see Channel.DisconnectReason
+func (c *Client) DisconnectReason() string {
+	channel := c.Channel()
+	if channel == nil {
+		return ""
+	}
+	return channel.DisconnectReason()
 }
 
+// Emit an asynchronous event with the given name and payload, ErrorNotConnected is returned
+// if the client has never been connected
+func (c *Client) Emit(name string, payload interface{}) error {
+	channel := c.Channel()
+	if channel == nil {
+		return ErrorNotConnected
+	}
+	return channel.Emit(name, payload)
+}
+
+// EmitVolatile emits an asynchronous event with the given name and payload without blocking,
+// see Channel.EmitVolatile. ErrorNotConnected is returned if the client has never been connected
+func (c *Client) EmitVolatile(name string, payload interface{}) error {
+	channel := c.Channel()
+	if channel == nil {
+		return ErrorNotConnected
+	}
+	return channel.EmitVolatile(name, payload)
+}
+
+// EmitAndWait emits a synchronous event with the given name and payload and waits for the ack response,
+// ErrorNotConnected is returned if the client has never been connected
+func (c *Client) EmitAndWait(name string, payload interface{}, timeout time.Duration) (string, error) {
+	channel := c.Channel()
+	if channel == nil {
+		return "", ErrorNotConnected
+	}
+	return channel.EmitAndWait(name, payload, timeout)
+}
+
+// EmitWithAck emits an event with the given name and payload requesting an ack, the callback cb is called
+// with the ack response in its own goroutine, see Channel.EmitWithAck. ErrorNotConnected is returned
+// if the client has never been connected
+func (c *Client) EmitWithAck(name string, payload interface{}, cb func(data string, err error)) error {
+	channel := c.Channel()
+	if channel == nil {
+		return ErrorNotConnected
+	}
+	return channel.EmitWithAck(name, payload, cb)
+}
+
+// Ack a synchronous event with the given name and payload and wait for/receive the response, see Channel.Ack.
+// ErrorNotConnected is returned if the client has never been connected
+func (c *Client) Ack(name string, payload interface{}, timeout time.Duration) (string, error) {
+	channel := c.Channel()
+	if channel == nil {
+		return "", ErrorNotConnected
+	}
+	return channel.Ack(name, payload, timeout)
+}
+
+// SendRaw queues the pre-formed engine.io packet to be sent as is, see Channel.SendRaw.
+// ErrorNotConnected is returned if the client has never been connected
+func (c *Client) SendRaw(packet string) error {
+	channel := c.Channel()
+	if channel == nil {
+		return ErrorNotConnected
+	}
+	return channel.SendRaw(packet)
+}
+
+// PendingAcks returns the amount of the acks requested by the current connection and waiting for the response
+func (c *Client) PendingAcks() int {
+	channel := c.Channel()
+	if channel == nil {
+		return 0
+	}
+	return channel.PendingAcks()
+}
+
+// OutboxLen returns the amount of the messages queued to be sent by the current connection and not written yet
+func (c *Client) OutboxLen() int {
+	channel := c.Channel()
+	if channel == nil {
+		return 0
+	}
+	return channel.OutboxLen()
+}
+
+// Subprotocol returns the websocket subprotocol negotiated with the server, it's empty for other transports
+func (c *Client) Subprotocol() string {
+	channel := c.Channel()
+	if channel == nil {
+		return ""
+	}
+	return channel.Subprotocol()
+}
+
+// HandshakeResponse returns the server response to the websocket upgrade request of the current connection,
+// see Channel.HandshakeResponse. It's nil if the client has never been connected
+func (c *Client) HandshakeResponse() *http.Response {
+	channel := c.Channel()
+	if channel == nil {
+		return nil
+	}
+	return channel.HandshakeResponse()
+}
+
+// SetTimeouts sets the receive and send timeouts of the current connection, see Channel.SetTimeouts
+func (c *Client) SetTimeouts(receive, send time.Duration) {
+	if channel := c.Channel(); channel != nil {
+		channel.SetTimeouts(receive, send)
+	}
+}
+
+// SetAckTimeout sets the ack response timeout of EmitWithAck for the connections dialed after the call,
+// the transport ping timeout is used by default
+func (c *Client) SetAckTimeout(d time.Duration) {
+	c.channelMu.Lock()
+	c.ackTimeout = d
+	c.channelMu.Unlock()
+}
+
+// SetAckOnPanic enables responding to an ack request of the server with an error object, if the handler panics.
+// Otherwise the ack request is left without response
+func (c *Client) SetAckOnPanic(enabled bool) { c.event.ackOnPanic = enabled }
+
+// SetAckOnUnknownEvent enables responding to an ack request of the server for an event without a handler
+// with an error object, so the server doesn't wait for the response until its timeout
+func (c *Client) SetAckOnUnknownEvent(enabled bool) { c.event.ackOnUnknown = enabled }
+
 // Close client connection
-func (c *Client) Close() { c.Channel.close(c.event) }
+func (c *Client) Close() {
+	if channel := c.Channel(); channel != nil {
+		channel.close(c.event)
+	}
+}
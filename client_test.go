@@ -0,0 +1,105 @@
+package socketio
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vanti-dev/golang-socketio/transport"
+)
+
+// newTestServer returns the server with both transports served by the test HTTP server
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	s := NewServer(transport.DefaultWebsocketTransport(), transport.DefaultPollingTransport(), nil)
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+// websocketURL returns the engine.io v3 websocket url of the test HTTP server
+func websocketURL(ts *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(ts.URL, "http") + DefaultPath + "?EIO=3&transport=websocket"
+}
+
+func TestClientEmitWithAckCallbackMayBlock(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.On("echo", func(c *Channel, v string) string { return v })
+
+	client := NewClient(nil)
+	if err := client.Dial(websocketURL(ts), transport.DefaultWebsocketTransport()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	resultC := make(chan string, 1)
+	err := client.EmitWithAck("echo", "first", func(data string, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		// waiting for another ack response in the callback doesn't hold the incoming loop
+		second, err := client.EmitAndWait("echo", "second", time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		resultC <- data + second
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case result := <-resultC:
+		if want := `"first""second"`; result != want {
+			t.Fatalf("result = %s, want %s", result, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the callback didn't complete")
+	}
+}
+
+func TestClientBeforeDial(t *testing.T) {
+	client := NewClient(nil)
+	if client.IsAlive() || client.Id() != "" || client.Channel() != nil {
+		t.Fatal("the client is connected before Dial")
+	}
+	if err := client.Emit("event", 1); err != ErrorNotConnected {
+		t.Fatalf("Emit() = %v, want ErrorNotConnected", err)
+	}
+	if _, err := client.Ack("event", 1, time.Second); err != ErrorNotConnected {
+		t.Fatalf("Ack() = %v, want ErrorNotConnected", err)
+	}
+	client.Close()
+}
+
+func TestClientRedialConcurrently(t *testing.T) {
+	_, ts := newTestServer(t)
+	client := NewClient(nil)
+	defer client.Close()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			client.Emit("event", 1)
+			client.IsAlive()
+			client.Id()
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		if err := client.Dial(websocketURL(ts), transport.DefaultWebsocketTransport()); err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+	}
+	close(stop)
+	<-done
+}
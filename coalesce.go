@@ -0,0 +1,69 @@
+package socketio
+
+import "time"
+
+// coalescedEmit is the event waiting for the end of its coalescing window, only its latest payload is emitted
+type coalescedEmit struct {
+	payload interface{}
+	timer   *time.Timer
+}
+
+// EmitCoalesced emits an asynchronous event with the given name and payload like Emit, but the events
+// of the same name emitted within the window are coalesced: only the latest payload is emitted at the end
+// of the window, once per window. It's intended for the high-frequency state updates where only the final
+// value matters. The payload is encoded when it's emitted, the errors are logged. The pending events
+// are dropped when the channel is closed. Zero window emits the event immediately
+func (c *Channel) EmitCoalesced(name string, payload interface{}, window time.Duration) error {
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		return upgraded.EmitCoalesced(name, payload, window)
+	}
+	if !c.IsAlive() {
+		return ErrorChannelClosed
+	}
+	if window <= 0 {
+		return c.Emit(name, payload)
+	}
+
+	c.coalescedMu.Lock()
+	defer c.coalescedMu.Unlock()
+
+	if pending, ok := c.coalesced[name]; ok {
+		pending.payload = payload
+		return nil
+	}
+	if c.coalesced == nil {
+		c.coalesced = make(map[string]*coalescedEmit)
+	}
+	c.coalesced[name] = &coalescedEmit{
+		payload: payload,
+		timer:   time.AfterFunc(window, func() { c.flushCoalesced(name) }),
+	}
+	return nil
+}
+
+// flushCoalesced emits the latest payload of the coalesced event with the given name
+func (c *Channel) flushCoalesced(name string) {
+	c.coalescedMu.Lock()
+	pending, ok := c.coalesced[name]
+	delete(c.coalesced, name)
+	c.coalescedMu.Unlock()
+
+	if !ok {
+		return
+	}
+	// Emit follows the channel replacing this one after the transport upgrade
+	if err := c.Emit(name, pending.payload); err != nil {
+		c.logger.Warn("Channel.flushCoalesced() can't emit the event:", "event", name, "err", err)
+	}
+}
+
+// stopCoalesced drops the pending coalesced events of the closed channel
+func (c *Channel) stopCoalesced() {
+	c.coalescedMu.Lock()
+	defer c.coalescedMu.Unlock()
+
+	for name, pending := range c.coalesced {
+		pending.timer.Stop()
+		delete(c.coalesced, name)
+	}
+}
@@ -0,0 +1,89 @@
+package socketio
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/vanti-dev/golang-socketio/protocol"
+	"github.com/vanti-dev/golang-socketio/transport"
+)
+
+// binaryPacketPrefix marks the queued packet encoded by the binary codec, it's written as the engine.io binary
+// message. It can't start an encoded packet
+const binaryPacketPrefix = "\x01"
+
+// errUnexpectedBinary is returned when the binary message is received outside of the binary packet attachments
+// by the channel whose codec isn't binary
+var errUnexpectedBinary = errors.New("unexpected binary message")
+
+// splitBinary returns the queued packet m without the binary mark and whether it's encoded by the binary codec
+func splitBinary(m string) (string, bool) {
+	if strings.HasPrefix(m, binaryPacketPrefix) {
+		return m[len(binaryPacketPrefix):], true
+	}
+	return m, false
+}
+
+// isEventType checks if the message type t is an event or an ack one
+func isEventType(t int) bool {
+	return t == protocol.MessageTypeEmit || t == protocol.MessageTypeAckRequest || t == protocol.MessageTypeAckResponse
+}
+
+// encodesBinary checks if the channel codec encodes the binary args values itself, they aren't sent
+// as attachments then
+func (c *Channel) encodesBinary() bool {
+	switch c.codec.(type) {
+	case protocol.ArgsCodec, protocol.BinaryCodec:
+		return true
+	}
+	return false
+}
+
+// encodePacket encodes the socket.io packet m by the channel codec. The packet of the binary codec is marked
+// to be written as the binary message, the text one is used if the connection can't send binary messages
+func (c *Channel) encodePacket(m *protocol.Message) (string, error) {
+	if codec, ok := c.codec.(protocol.BinaryCodec); ok && c.supportsBinary() {
+		data, err := codec.EncodeBinary(m)
+		if err != nil {
+			return "", err
+		}
+		return binaryPacketPrefix + string(data), nil
+	}
+	return c.codec.Encode(m)
+}
+
+// decodeBinaryPacket decodes the engine.io binary frame received outside of the binary packet attachments
+// by the binary codec of the channel
+func (c *Channel) decodeBinaryPacket(frame []byte) (*protocol.Message, error) {
+	codec, ok := c.codec.(protocol.BinaryCodec)
+	if !ok {
+		return nil, errUnexpectedBinary
+	}
+	data, err := protocol.DecodeAttachment(frame)
+	if err != nil {
+		return nil, err
+	}
+	return codec.DecodeBinary(data)
+}
+
+// readMessage reads the following text message or the engine.io binary frame from the connection,
+// the binary messages are read only if the channel codec is binary
+func (c *Channel) readMessage() (string, []byte, error) {
+	if reader, ok := c.conn.(transport.MessageReader); ok {
+		if _, binary := c.codec.(protocol.BinaryCodec); binary {
+			return reader.ReadMessage()
+		}
+	}
+	m, err := c.conn.GetMessage()
+	return m, nil, err
+}
+
+// unmarshalArgs decodes the args received by the channel c into the value pointed by v by the channel codec,
+// it returns false if the codec doesn't encode the args, they're JSON then
+func (c *Channel) unmarshalArgs(args string, v interface{}) (bool, error) {
+	codec, ok := c.codec.(protocol.ArgsCodec)
+	if !ok {
+		return false, nil
+	}
+	return true, codec.UnmarshalArgs(args, v)
+}
@@ -0,0 +1,148 @@
+package socketio
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/vanti-dev/golang-socketio/protocol"
+	"github.com/vanti-dev/golang-socketio/transport"
+)
+
+// lineCodec encodes the args as JSON values on separate lines and the socket.io packets as NUL separated
+// binary fields, the engine.io packets are text
+type lineCodec struct{ protocol.JSONCodec }
+
+func (lineCodec) MarshalArgs(args []interface{}) (string, error) {
+	lines := make([]string, len(args))
+	for i, arg := range args {
+		b, err := json.Marshal(arg)
+		if err != nil {
+			return "", err
+		}
+		lines[i] = string(b)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (lineCodec) UnmarshalArgs(data string, args ...interface{}) error {
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(args) && i < len(lines); i++ {
+		if err := json.Unmarshal([]byte(lines[i]), args[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lineCodec) EncodeBinary(m *protocol.Message) ([]byte, error) {
+	return []byte(fmt.Sprintf("%d\x00%d\x00%s\x00%s", m.Type, m.AckID, m.EventName, m.Args)), nil
+}
+
+func (lineCodec) DecodeBinary(data []byte) (*protocol.Message, error) {
+	fields := strings.SplitN(string(data), "\x00", 4)
+	if len(fields) != 4 {
+		return nil, protocol.ErrorWrongPacket
+	}
+	m := &protocol.Message{EventName: fields[2], Args: fields[3]}
+	var err error
+	if m.Type, err = strconv.Atoi(fields[0]); err != nil {
+		return nil, err
+	}
+	if m.AckID, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readBinaryPacket reads the following binary message from the engine.io v3 websocket and decodes it by lineCodec
+func readBinaryPacket(t *testing.T, conn *websocket.Conn) *protocol.Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msgType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != websocket.BinaryMessage || len(data) == 0 || data[0] != 4 {
+		t.Fatalf("message = %d %q, want the binary one", msgType, data)
+	}
+	m, err := lineCodec{}.DecodeBinary(data[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// writeBinaryPacket writes the packet m encoded by lineCodec as the binary message into the engine.io v3 websocket
+func writeBinaryPacket(t *testing.T, conn *websocket.Conn, m *protocol.Message) {
+	t.Helper()
+	data, _ := lineCodec{}.EncodeBinary(m)
+	if err := conn.WriteMessage(websocket.BinaryMessage, append([]byte{4}, data...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCodecEncodesArgsAndPackets(t *testing.T) {
+	s := NewServer(transport.DefaultWebsocketTransport(), nil, nil, lineCodec{})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	s.On("join", func(c *Channel, name string, n int) string { return strings.Repeat(name, n) })
+	typedC := make(chan []byte, 1)
+	if err := OnTyped(s, "typed", func(c *Channel, data []byte) { typedC <- data }); err != nil {
+		t.Fatal(err)
+	}
+	connectedC := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connectedC <- c })
+
+	conn, _, err := websocket.DefaultDialer.Dial(websocketURL(ts), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the open packet is text, the connect one is encoded by the codec
+	if msgType, data, err := conn.ReadMessage(); err != nil || msgType != websocket.TextMessage || data[0] != '0' {
+		t.Fatalf("open packet = %d %q, %v", msgType, data, err)
+	}
+	if m := readBinaryPacket(t, conn); m.Type != protocol.MessageTypeEmpty {
+		t.Fatalf("connect packet type = %d", m.Type)
+	}
+	var c *Channel
+	select {
+	case c = <-connectedC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the channel didn't connect")
+	}
+
+	writeBinaryPacket(t, conn, &protocol.Message{Type: protocol.MessageTypeAckRequest, AckID: 1, EventName: "join", Args: "\"ab\"\n3"})
+	m := readBinaryPacket(t, conn)
+	if m.Type != protocol.MessageTypeAckResponse || m.AckID != 1 || m.Args != `"ababab"` {
+		t.Fatalf("ack response = %+v", m)
+	}
+
+	writeBinaryPacket(t, conn, &protocol.Message{Type: protocol.MessageTypeEmit, EventName: "typed", Args: `"AQI="`})
+	select {
+	case data := <-typedC:
+		if string(data) != "\x01\x02" {
+			t.Fatalf("typed arg = %q", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the typed handler wasn't called")
+	}
+
+	// the binary args are encoded by the codec instead of the attachments
+	if err := c.Emit("data", []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	m = readBinaryPacket(t, conn)
+	if m.Type != protocol.MessageTypeEmit || m.EventName != "data" || m.Args != `"AQI="` {
+		t.Fatalf("emitted packet = %+v", m)
+	}
+}
@@ -0,0 +1,53 @@
+package socketio
+
+import (
+	"strings"
+
+	"github.com/vanti-dev/golang-socketio/protocol"
+	"github.com/vanti-dev/golang-socketio/transport"
+)
+
+// noCompressPrefix marks the queued packet to be written without the per-message compression,
+// it can't start an encoded packet
+const noCompressPrefix = "\x00"
+
+// EmitNoCompress emits an asynchronous event with the given name and payload like Emit, but the websocket frame
+// isn't compressed even if the permessage-deflate compression is negotiated, e.g. for the small frames
+// not worth compressing
+func (c *Channel) EmitNoCompress(name string, payload interface{}) error {
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		return upgraded.EmitNoCompress(name, payload)
+	}
+	if !c.IsAlive() {
+		return ErrorChannelClosed
+	}
+
+	m := &protocol.Message{Type: protocol.MessageTypeEmit, EventName: name}
+	command, err := c.encode(m, payload)
+	if err != nil {
+		return err
+	}
+
+	if !c.enqueue(noCompressPrefix+command, m.Attachments, true) {
+		return ErrorSendQueueTimeout
+	}
+	c.metrics().OnMessageOut(c, m.EventName)
+	return nil
+}
+
+// splitCompress returns the queued packet m without the no-compress mark and whether it should be compressed.
+// The heartbeat packets are never compressed
+func splitCompress(m string) (string, bool) {
+	if strings.HasPrefix(m, noCompressPrefix) {
+		return m[len(noCompressPrefix):], false
+	}
+	return m, m != protocol.MessagePing && m != protocol.MessagePong
+}
+
+// writeMessage writes the packet m into the connection, without the per-message compression if compress is false
+func (c *Channel) writeMessage(m string, compress bool) error {
+	if ws, ok := c.conn.(*transport.WebsocketConnection); ok && !compress {
+		return ws.WriteMessageNoCompress(m)
+	}
+	return c.conn.WriteMessage(m)
+}
@@ -0,0 +1,71 @@
+package socketio
+
+import (
+	"errors"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/vanti-dev/golang-socketio/protocol"
+)
+
+// eioVersion4 is the engine.io protocol version of the socket.io v3/v4 clients. They connect to a namespace
+// explicitly with the CONNECT packet after the engine.io handshake
+const eioVersion4 = "4"
+
+// errInvalidNamespace is sent in the CONNECT_ERROR packet to the client connecting to an unknown namespace
+var errInvalidNamespace = errors.New("Invalid namespace")
+
+// connectsExplicitly checks if the client connecting with the given URL query sends the CONNECT packet
+func connectsExplicitly(query url.Values) bool { return query.Get("EIO") == eioVersion4 }
+
+// Auth returns the raw auth payload of the socket.io v3/v4 CONNECT packet, it's empty for earlier clients.
+// The payload is JSON unless the server codec encodes the args
+func (c *Channel) Auth() string {
+	c.reasonMu.Lock()
+	defer c.reasonMu.Unlock()
+	return c.auth
+}
+
+// takeConnectPending clears the flag of the channel awaiting the CONNECT packet, it returns false if it wasn't set
+func (c *Channel) takeConnectPending() bool {
+	return atomic.CompareAndSwapInt32(&c.connectPending, 1, 0)
+}
+
+// isConnectPending checks if the channel awaits the CONNECT packet
+func (c *Channel) isConnectPending() bool { return atomic.LoadInt32(&c.connectPending) == 1 }
+
+// acceptConnect handles the CONNECT packet m of the channel c. The connection to the default namespace
+// is acknowledged and OnConnection handler fires, the other namespaces are refused with the CONNECT_ERROR packet.
+// The packet is ignored if the channel doesn't await it
+func (s *Server) acceptConnect(c *Channel, m *protocol.Message) {
+	if !c.isConnectPending() {
+		return
+	}
+
+	if m.Namespace != "" && m.Namespace != DefaultNamespace {
+		s.logger.Info("Server.acceptConnect() refused connection to namespace:", "namespace", m.Namespace)
+		c.send(&protocol.Message{Type: protocol.MessageTypeConnectErr, Namespace: m.Namespace},
+			map[string]string{"message": errInvalidNamespace.Error()})
+		return
+	}
+
+	if !c.takeConnectPending() {
+		return
+	}
+	c.reasonMu.Lock()
+	c.auth = m.Args
+	c.reasonMu.Unlock()
+
+	// the channel holds the sid after the connection handler is called
+	defer s.releaseSid(c.Id())
+
+	if err := c.send(&protocol.Message{Type: protocol.MessageTypeEmpty}, map[string]string{"sid": c.Id()}); err != nil {
+		s.logger.Warn("Server.acceptConnect() can't acknowledge the connection:", "err", err)
+		c.closeWithReason(s.event, DisconnectReasonTransportError)
+		return
+	}
+
+	c.setState(StateConnected)
+	s.callHandler(c, OnConnection)
+	s.metrics.OnConnect(c)
+}
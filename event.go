@@ -1,12 +1,12 @@
 package socketio
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"go.uber.org/zap"
 	"reflect"
 	"sync"
 
+	"github.com/vanti-dev/golang-socketio/logging"
 	"github.com/vanti-dev/golang-socketio/protocol"
 )
 
@@ -14,20 +14,50 @@ const (
 	OnConnection    = "connection"
 	OnDisconnection = "disconnection"
 	OnError         = "error"
+	OnReconnect     = "reconnect"
 )
 
+// EventError describes a failure of the incoming event processing, it's passed to the OnError handler
+// if the handler accepts it as a parameter
+type EventError struct {
+	Event     string      // event name
+	Args      string      // raw event arguments
+	Err       error       // error occurred
+	Recovered interface{} // value recovered from the handler panic, if any
+}
+
+// Error implements error interface
+func (e *EventError) Error() string { return fmt.Sprintf("event %q: %v", e.Event, e.Err) }
+
+// Unwrap returns the underlying error
+func (e *EventError) Unwrap() error { return e.Err }
+
+// errArgsDecoding is returned internally when event args can't be decoded for the handler
+var errArgsDecoding = errors.New("event args decoding failed")
+
+// errUnknownEvent is sent in the ack response to the ack request for an event without a handler
+var errUnknownEvent = errors.New("unknown event")
+
 // systemEventHandler function for internal handler processing
 type systemEventHandler func(c *Channel)
 
 // event abstracts a mapping of a handler names to handler functions
 type event struct {
 	handlers   map[string]*handler // maps handler name to handler function representation
+	onAny      func(c *Channel, name, args string)
 	handlersMu sync.RWMutex
 
 	onConnection    systemEventHandler
 	onDisconnection systemEventHandler
 
-	logger *zap.Logger
+	ackOnPanic   bool // respond to an ack request with an error if the handler panics
+	ackOnUnknown bool // respond to an ack request with an error if there is no handler for the event
+	useNumber    bool // decode numbers of event args into interface{} values as json.Number
+	json         JSON // nil means StdJSON
+
+	validator func(interface{}) error // validates the decoded struct args, nil means no validation
+
+	logger logging.Logger
 }
 
 // init initializes events mapping
@@ -40,10 +70,56 @@ func (e *event) On(name string, f interface{}) error {
 		return err
 	}
 
+	e.setHandler(name, c)
+	return nil
+}
+
+// unmarshal decodes event args data into v, numbers are decoded as json.Number if useNumber is set
+// and the JSON implementation supports it
+func (e *event) unmarshal(data []byte, v interface{}) error {
+	var j JSON = StdJSON{}
+	if e.json != nil {
+		j = e.json
+	}
+	if numberJSON, ok := j.(NumberJSON); ok && e.useNumber {
+		return numberJSON.UnmarshalUseNumber(data, v)
+	}
+	return j.Unmarshal(data, v)
+}
+
+// checkUseNumber warns if the numbers should be decoded as json.Number, but the JSON implementation can't do it
+func (e *event) checkUseNumber() {
+	if _, ok := e.json.(NumberJSON); e.useNumber && e.json != nil && !ok {
+		e.logger.Warn("event.checkUseNumber() the JSON implementation doesn't implement NumberJSON, numbers are decoded by it")
+	}
+}
+
+// setHandler binds the handler representation f to the given event name
+func (e *event) setHandler(name string, f *handler) {
+	e.handlersMu.Lock()
+	e.handlers[name] = f
+	e.handlersMu.Unlock()
+}
+
+// OnAny registers a catch-all function fired on every incoming event, whether a specific handler for it
+// is registered or not. Event args are passed raw
+func (e *event) OnAny(f func(c *Channel, name, args string)) {
 	e.handlersMu.Lock()
-	e.handlers[name] = c
+	e.onAny = f
 	e.handlersMu.Unlock()
+}
 
+// callAny fires the catch-all function registered with OnAny for the message m, recovering from a panic in it
+func (e *event) callAny(c *Channel, m *protocol.Message) (err error) {
+	e.handlersMu.RLock()
+	f := e.onAny
+	e.handlersMu.RUnlock()
+	if f == nil {
+		return nil
+	}
+
+	defer e.recoverHandler(c, m.EventName, m.Args, &err)
+	f(c, m.EventName, m.Args)
 	return nil
 }
 
@@ -73,56 +149,200 @@ func (e *event) callHandler(c *Channel, name string) {
 		return
 	}
 
-	f.call(c, &struct{}{})
+	// OnDisconnection handler may accept the disconnection reason
+	if name == OnDisconnection && f.hasArgs && f.args.Kind() == reflect.String {
+		reason := reflect.New(f.args)
+		reason.Elem().SetString(c.DisconnectReason())
+		e.call(c, f, name, "", reason.Interface())
+		return
+	}
+
+	e.call(c, f, name, "", &struct{}{})
+}
+
+// call the handler f of the event name for the channel c with the given arguments, recovering from
+// a panic in the handler. The panic is reported to the OnError handler and returned as an error
+func (e *event) call(c *Channel, f *handler, name, args string, arguments interface{}) (result []reflect.Value, err error) {
+	if !f.opts.NoRecover {
+		defer e.recoverHandler(c, name, args, &err)
+	}
+	return f.call(c, arguments), nil
+}
+
+// callTyped calls the typed handler f with args of the message m, recovering from a panic in the handler.
+// Args decoding or validation error is reported to the OnError handler and errArgsDecoding is returned
+func (e *event) callTyped(c *Channel, f *handler, m *protocol.Message) (err error) {
+	if !f.opts.NoRecover {
+		defer e.recoverHandler(c, m.EventName, m.Args, &err)
+	}
+
+	if err := f.typed(c, m.Args, e.argDecoder(c)); err != nil {
+		e.logger.Info("event.callTyped() failed to decode args", "err", err)
+		e.callErrorHandler(c, &EventError{Event: m.EventName, Args: m.Args, Err: err})
+		return errArgsDecoding
+	}
+	return nil
+}
+
+// callWithArgs decodes args of the message m according to the handler f parameters, and calls it. The handler
+// accepting the whole message is called with m itself. Decoding or validation error is reported to the OnError
+// handler and errArgsDecoding is returned
+func (e *event) callWithArgs(c *Channel, f *handler, m *protocol.Message) ([]reflect.Value, error) {
+	switch {
+	case !f.hasArgs:
+		return e.call(c, f, m.EventName, m.Args, &struct{}{})
+
+	case f.raw:
+		return e.callValues(c, f, m.EventName, m.Args, []reflect.Value{reflect.ValueOf(m)})
+
+	case len(f.params) > 1:
+		var values []reflect.Value
+		var err error
+		if codec, ok := c.codec.(protocol.ArgsCodec); ok {
+			values, err = f.argumentsDecoded(m.Args, codec)
+		} else {
+			values, err = f.argumentsList(m.Args, e.unmarshal)
+		}
+		for i := 0; err == nil && i < len(values); i++ {
+			err = e.validateArg(values[i].Addr().Interface())
+		}
+		if err != nil {
+			e.logger.Info("event.callWithArgs() failed to decode args", "args", m.Args, "err", err)
+			e.callErrorHandler(c, &EventError{Event: m.EventName, Args: m.Args, Err: err})
+			return nil, errArgsDecoding
+		}
+		return e.callValues(c, f, m.EventName, m.Args, values)
+	}
+
+	// data type should be defined for Unmarshal()
+	data := f.arguments()
+	if logging.DebugEnabled(e.logger) {
+		e.logger.Debug("event.callWithArgs(), f.arguments() returned:", "data", data)
+	}
+
+	decoded, err := c.unmarshalArgs(m.Args, data)
+	if !decoded {
+		err = e.unmarshal([]byte(m.Args), &data)
+	}
+	if err != nil {
+		e.logger.Info(fmt.Sprintf("event.callWithArgs() failed to json.Unmarshal(). msg.Args: %s, data: %v, err: %v",
+			m.Args, data, err))
+		e.callErrorHandler(c, &EventError{Event: m.EventName, Args: m.Args, Err: err})
+		return nil, errArgsDecoding
+	}
+	if err := e.validateArg(data); err != nil {
+		e.logger.Info("event.callWithArgs() args validation failed", "args", m.Args, "err", err)
+		e.callErrorHandler(c, &EventError{Event: m.EventName, Args: m.Args, Err: err})
+		return nil, errArgsDecoding
+	}
+
+	return e.call(c, f, m.EventName, m.Args, data)
+}
+
+// callValues calls the handler f with the given argument values, recovering from a panic in the handler
+func (e *event) callValues(c *Channel, f *handler, name, args string, values []reflect.Value) (result []reflect.Value, err error) {
+	if !f.opts.NoRecover {
+		defer e.recoverHandler(c, name, args, &err)
+	}
+	return f.callValues(c, values), nil
+}
+
+// recoverHandler recovers from a panic in the handler of the event name and reports it to the OnError handler,
+// it should be deferred. The panic is returned into err
+func (e *event) recoverHandler(c *Channel, name, args string, err *error) {
+	if r := recover(); r != nil {
+		e.logger.Warn("event.recoverHandler(): recovered from handler panic:", "event", name, "r", r)
+		eventErr := &EventError{Event: name, Args: args, Err: fmt.Errorf("handler panic: %v", r), Recovered: r}
+		e.callErrorHandler(c, eventErr)
+		*err = eventErr
+	}
+}
+
+// callErrorHandler fires the OnError handler for the given channel c with err
+func (e *event) callErrorHandler(c *Channel, err *EventError) {
+	f, ok := e.findHandler(OnError)
+	if !ok {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			e.logger.Warn("event.callErrorHandler(): recovered from panic:", "r", r)
+		}
+	}()
+
+	if !f.hasArgs {
+		f.call(c, &struct{}{})
+		return
+	}
+
+	arguments := reflect.New(f.args)
+	if reflect.TypeOf(err).AssignableTo(f.args) {
+		arguments.Elem().Set(reflect.ValueOf(err))
+	}
+	f.call(c, arguments.Interface())
 }
 
 // processIncoming checks incoming message m on channel c
 func (e *event) processIncoming(c *Channel, m *protocol.Message) {
-	e.logger.Debug("event.processIncoming() fired with:", zap.Any("m", m))
+	if logging.DebugEnabled(e.logger) {
+		e.logger.Debug("event.processIncoming() fired with:", "m", m)
+	}
 	switch m.Type {
 	case protocol.MessageTypeEmit:
-		e.logger.Debug("event.processIncoming() is finding handler for msg.Event:", zap.String("EventName", m.EventName))
+		c.metrics().OnMessageIn(c, m.EventName)
+		e.callAny(c, m)
+
+		if logging.DebugEnabled(e.logger) {
+			e.logger.Debug("event.processIncoming() is finding handler for msg.Event:", "EventName", m.EventName)
+		}
 		f, ok := e.findHandler(m.EventName)
 		if !ok {
 			e.logger.Debug("event.processIncoming(): handler not found")
 			return
 		}
 
-		e.logger.Debug("event.processIncoming() found handler:", zap.Any("f", f))
-
-		if !f.hasArgs {
-			f.call(c, &struct{}{})
-			return
+		if logging.DebugEnabled(e.logger) {
+			e.logger.Debug("event.processIncoming() found handler:", "f", f)
 		}
 
-		data := f.arguments()
-		e.logger.Debug("event.processIncoming(), f.arguments() returned:", zap.Any("data", data))
-
-		if err := json.Unmarshal([]byte(m.Args), &data); err != nil {
-			e.logger.Info(fmt.Sprintf("event.processIncoming() failed to json.Unmaeshal(). msg.Args: %s, data: %v, err: %v",
-				m.Args, data, err))
-			return
+		timer := e.startTimer(c, f, m)
+		if f.typed != nil {
+			e.callTyped(c, f, m)
+		} else {
+			e.callWithArgs(c, f, m)
 		}
-
-		f.call(c, data)
+		timer.stop()
 
 	case protocol.MessageTypeAckRequest:
 		e.logger.Debug("event.processIncoming() ack request")
+		c.metrics().OnMessageIn(c, m.EventName)
+		e.callAny(c, m)
+
+		// the handler accepting the whole message may respond with Channel.Reply
 		f, ok := e.findHandler(m.EventName)
-		if !ok || !f.out {
+		if !ok && e.ackOnUnknown {
+			ackResponse := &protocol.Message{Type: protocol.MessageTypeAckResponse, AckID: m.AckID}
+			c.send(ackResponse, map[string]string{"error": errUnknownEvent.Error()})
+			return
+		}
+		if !ok || !f.out && !f.raw && f.typed == nil {
 			return
 		}
 
-		var result []reflect.Value
-		if f.hasArgs {
-			// data type should be defined for Unmarshal()
-			data := f.arguments()
-			if err := json.Unmarshal([]byte(m.Args), &data); err != nil {
-				return
-			}
-			result = f.call(c, data)
+		// the typed handler doesn't return values, so the ack response is sent only on its panic
+		var (
+			result []reflect.Value
+			err    error
+		)
+		timer := e.startTimer(c, f, m)
+		if f.typed != nil {
+			err = e.callTyped(c, f, m)
 		} else {
-			result = f.call(c, &struct{}{})
+			result, err = e.callWithArgs(c, f, m)
+		}
+		if !timer.stop() || err == errArgsDecoding || err == nil && !f.out {
+			return
 		}
 
 		ackResponse := &protocol.Message{
@@ -130,10 +350,30 @@ func (e *event) processIncoming(c *Channel, m *protocol.Message) {
 			AckID: m.AckID,
 		}
 
-		c.send(ackResponse, result[0].Interface())
+		if err != nil {
+			if e.ackOnPanic {
+				c.send(ackResponse, map[string]string{"error": err.Error()})
+			}
+			return
+		}
+
+		// all the returned values are sent as ack response args
+		payloads := make([]interface{}, len(result))
+		for i := range result {
+			payloads[i] = result[i].Interface()
+		}
+		if err := c.send(ackResponse, payloads...); err != nil {
+			e.logger.Info("event.processIncoming() failed to send ack response:", "err", err)
+			e.callErrorHandler(c, &EventError{Event: m.EventName, Args: m.Args, Err: err})
+		}
 
 	case protocol.MessageTypeAckResponse:
 		e.logger.Debug("event.processIncoming() ack response")
+		// the callback may block, e.g. waiting for another ack response, so it doesn't hold the incoming loop
+		if cb := c.ack.takeCallback(m.AckID); cb != nil {
+			go cb.f(m.Args, nil)
+			return
+		}
 		ackC, err := c.ack.obtain(m.AckID)
 		if err == nil {
 			ackC <- m.Args
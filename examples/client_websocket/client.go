@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/vanti-dev/golang-socketio/examples/model"
+	"github.com/vanti-dev/golang-socketio/logging"
 	"github.com/vanti-dev/golang-socketio/transport"
 )
 
@@ -55,7 +56,7 @@ func main() {
 	client, err := socketio.Dial(
 		socketio.AddrWebsocket("localhost", serverPort, false),
 		transport.DefaultWebsocketTransport(),
-		logger,
+		logging.NewZap(logger),
 	)
 	if err != nil {
 		log.Fatal(err)
@@ -9,6 +9,7 @@ import (
 
 	"github.com/vanti-dev/golang-socketio"
 	"github.com/vanti-dev/golang-socketio/examples/model"
+	"github.com/vanti-dev/golang-socketio/logging"
 )
 
 const (
@@ -55,7 +56,7 @@ func main() {
 	client, err := socketio.Dial(
 		socketio.AddrPolling("localhost", serverPort, false),
 		transport.DefaultPollingClientTransport(),
-		logger,
+		logging.NewZap(logger),
 	)
 	if err != nil {
 		log.Fatal(err)
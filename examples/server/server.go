@@ -12,6 +12,7 @@ import (
 
 	"github.com/vanti-dev/golang-socketio"
 	"github.com/vanti-dev/golang-socketio/examples/model"
+	"github.com/vanti-dev/golang-socketio/logging"
 )
 
 var assetsDir http.FileSystem
@@ -30,7 +31,7 @@ func onConnectionHandler(c *socketio.Channel)    { log.Printf("Connected %s\n",
 func onDisconnectionHandler(c *socketio.Channel) { log.Printf("Disconnected %s\n", c.Id()) }
 func onJoinHandler(c *socketio.Channel, roomName string) string {
 	log.Printf("Join %s to room %s\n", c.Id(), roomName)
-	if err := c.Join(roomName); err != nil {
+	if _, err := c.Join(roomName); err != nil {
 		return err.Error()
 	}
 	return OK
@@ -62,7 +63,7 @@ func onSendHandler(c *socketio.Channel, param interface{}) interface{} {
 }
 func onLeaveHandler(c *socketio.Channel, roomName string) string {
 	log.Printf("Leave %s from room %s\n", c.Id(), roomName)
-	if err := c.Leave(roomName); err != nil {
+	if _, err := c.Leave(roomName); err != nil {
 		return err.Error()
 	}
 	return OK
@@ -85,12 +86,13 @@ func main() {
 
 	logger.Debug("", zap.Any("assetsDir", assetsDir))
 
+	socketLogger := logging.NewZap(logger)
 	server := socketio.NewServer(
 		transport.NewWebsocketTransport(transport.WebsocketTransportParams{}, func(r *http.Request) bool {
 			return true
-		}, logger),
-		transport.NewPollingTransport(logger),
-		logger)
+		}, socketLogger),
+		transport.NewPollingTransport(socketLogger),
+		socketLogger)
 	if err := server.On(socketio.OnConnection, onConnectionHandler); err != nil {
 		logger.Fatal("", zap.Error(err))
 	}
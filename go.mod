@@ -1,10 +1,15 @@
 module github.com/vanti-dev/golang-socketio
 
-go 1.12
+go 1.18
 
 require (
 	github.com/gorilla/websocket v1.5.0
 	github.com/mtfelian/synced v1.0.0
 	go.uber.org/zap v1.21.0
+)
+
+require (
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
 	golang.org/x/sys v0.0.0-20220627191245-f75cf1eec38b // indirect
 )
@@ -1,28 +1,49 @@
 package socketio
 
 import (
+	"encoding/json"
 	"errors"
 	"reflect"
+
+	"github.com/vanti-dev/golang-socketio/protocol"
 )
 
 // handler is an event handler representation
 type handler struct {
 	function reflect.Value
-	args     reflect.Type
+	args     reflect.Type   // the first parameter after the channel
+	params   []reflect.Type // all the parameters after the channel
 	hasArgs  bool
 	out      bool
+	raw      bool // the handler accepts the whole message instead of the decoded args
+	opts     HandlerOptions
+
+	typed func(c *Channel, args string, decode unmarshalFunc) error // decodes args and calls the handler without reflection
 }
 
+// unmarshalFunc decodes the args data into v, JSON unless the codec encodes the args
+type unmarshalFunc func(data []byte, v interface{}) error
+
+// messageType is a type of the handler param accepting the whole message
+var messageType = reflect.TypeOf(&protocol.Message{})
+
 var (
 	ErrorHandlerIsNotFunc   = errors.New("f is not a function")
-	ErrorHandlerHasNot2Args = errors.New("f should have 1 or 2 arguments")
+	ErrorHandlerHasNot2Args = errors.New("f should have at least 1 argument")
+	ErrorTypedSystemEvent   = errors.New("typed handler can't be registered for the connection, disconnection or error event")
+
+	// Deprecated: the handlers may return several values, they're all sent as the ack response args,
+	// so it isn't returned anymore
 	ErrorHandlerWrongResult = errors.New("f should return no more than one value")
 )
 
 // newHandler parses function f (event handler) using reflection, and stores its representation
 //
-// f should be of the form `func (c *Channel, [body &interface{}]) [&interface{}]`. The body param and return type are
-// optional, and are used to convert to/from json for sending over the websocket
+// f should be of the form `func (c *Channel, [body &interface{}]...) [&interface{}...]`. The body params and return values
+// are optional, and are used to convert to/from json for sending over the websocket. If there are several body params,
+// event args are decoded positionally: missing ones are left zero valued, extra ones are ignored. All the return values
+// are sent as the ack response args. If the only body param is *protocol.Message, the handler is called
+// with the whole incoming message instead of the decoded args
 func newHandler(f interface{}) (*handler, error) {
 	fVal := reflect.ValueOf(f)
 	if fVal.Kind() != reflect.Func {
@@ -30,32 +51,100 @@ func newHandler(f interface{}) (*handler, error) {
 	}
 
 	fType := fVal.Type()
-	if fType.NumOut() > 1 {
-		return nil, ErrorHandlerWrongResult
-	}
-
 	curCaller := &handler{
 		function: fVal,
-		out:      fType.NumOut() == 1,
+		out:      fType.NumOut() > 0,
 	}
 
 	switch fType.NumIn() {
+	case 0:
+		return nil, ErrorHandlerHasNot2Args
 	case 1:
 		curCaller.args = nil
 		curCaller.hasArgs = false
-	case 2:
+	default:
 		curCaller.args = fType.In(1)
 		curCaller.hasArgs = true
-	default:
-		return nil, ErrorHandlerHasNot2Args
+		for i := 1; i < fType.NumIn(); i++ {
+			curCaller.params = append(curCaller.params, fType.In(i))
+		}
+		curCaller.raw = fType.NumIn() == 2 && curCaller.args == messageType
 	}
 
 	return curCaller, nil
 }
 
+// OnTyped registers a typed event handler for the given event name. The argument type is known at compile time,
+// so args are unmarshalled directly into it, bypassing reflection on every call. The argument is validated
+// by the server validator, if set. Typed handlers coexist with the ones registered by On, the last registered
+// handler for the name wins. The OnConnection, OnDisconnection and OnError handlers aren't called with event args,
+// so ErrorTypedSystemEvent is returned for them
+func OnTyped[T any](s *Server, name string, fn func(c *Channel, arg T)) error {
+	switch name {
+	case OnConnection, OnDisconnection, OnError:
+		return ErrorTypedSystemEvent
+	}
+
+	s.event.setHandler(name, &handler{
+		typed: func(c *Channel, args string, decode unmarshalFunc) error {
+			var arg T
+			if err := decode([]byte(args), &arg); err != nil {
+				return err
+			}
+			fn(c, arg)
+			return nil
+		},
+	})
+	return nil
+}
+
 // arguments returns function parameter as it is present in it using reflection
 func (h *handler) arguments() interface{} { return reflect.New(h.args).Interface() }
 
+// argumentsList decodes args being a JSON array content into the function parameters values
+func (h *handler) argumentsList(args string, unmarshal unmarshalFunc) ([]reflect.Value, error) {
+	var raw []json.RawMessage
+	if err := unmarshal([]byte("["+args+"]"), &raw); err != nil {
+		return nil, err
+	}
+
+	values := make([]reflect.Value, len(h.params))
+	for i, param := range h.params {
+		value := reflect.New(param)
+		if i < len(raw) {
+			if err := unmarshal(raw[i], value.Interface()); err != nil {
+				return nil, err
+			}
+		}
+		values[i] = value.Elem()
+	}
+
+	return values, nil
+}
+
+// argumentsDecoded decodes args encoded by the codec into the function parameters values
+func (h *handler) argumentsDecoded(args string, codec protocol.ArgsCodec) ([]reflect.Value, error) {
+	values := make([]reflect.Value, len(h.params))
+	pointers := make([]interface{}, len(h.params))
+	for i, param := range h.params {
+		values[i] = reflect.New(param)
+		pointers[i] = values[i].Interface()
+	}
+	if err := codec.UnmarshalArgs(args, pointers...); err != nil {
+		return nil, err
+	}
+
+	for i := range values {
+		values[i] = values[i].Elem()
+	}
+	return values, nil
+}
+
+// callValues calls func with the given parameters values using reflection
+func (h *handler) callValues(c *Channel, values []reflect.Value) []reflect.Value {
+	return h.function.Call(append([]reflect.Value{reflect.ValueOf(c)}, values...))
+}
+
 // call func with given arguments from its representation using reflection
 func (h *handler) call(c *Channel, arguments interface{}) []reflect.Value {
 	// nil is untyped, so use the default empty value of correct type
@@ -68,5 +157,10 @@ func (h *handler) call(c *Channel, arguments interface{}) []reflect.Value {
 		a = a[0:1]
 	}
 
+	// the rest of parameters are zero valued
+	for i := 1; i < len(h.params); i++ {
+		a = append(a, reflect.Zero(h.params[i]))
+	}
+
 	return h.function.Call(a)
 }
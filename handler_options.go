@@ -0,0 +1,89 @@
+package socketio
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/vanti-dev/golang-socketio/protocol"
+)
+
+// ErrorHandlerTimeout is reported to the OnError handler if the handler runs longer than its timeout,
+// the ack request is responded with it
+var ErrorHandlerTimeout = errors.New("handler timeout")
+
+// HandlerOptions adjust how the handler registered with OnWithOptions is called
+type HandlerOptions struct {
+	// Inline calls the handler in the incoming loop of the channel instead of its own goroutine, it suits
+	// the fast handlers. The channel doesn't read the following messages until the handler returns, so it
+	// must not wait for the acks of the channel and should return well within the ping timeout
+	Inline bool
+
+	// Timeout is the time after which the handler still running is reported to the OnError handler with
+	// ErrorHandlerTimeout and the ack request is responded with it, the handler isn't interrupted.
+	// Zero means no timeout
+	Timeout time.Duration
+
+	// NoRecover lets the handler panic crash the program instead of being recovered and reported
+	// to the OnError handler
+	NoRecover bool
+}
+
+// OnWithOptions registers message processing function and binds it to the given event name,
+// the function is called according to opts
+func (e *event) OnWithOptions(name string, f interface{}, opts HandlerOptions) error {
+	c, err := newHandler(f)
+	if err != nil {
+		return err
+	}
+
+	c.opts = opts
+	e.setHandler(name, c)
+	return nil
+}
+
+// isInline checks if the message m is processed by the inline handler
+func (e *event) isInline(m *protocol.Message) bool {
+	if m.Type != protocol.MessageTypeEmit && m.Type != protocol.MessageTypeAckRequest {
+		return false
+	}
+	f, ok := e.findHandler(m.EventName)
+	return ok && f.opts.Inline
+}
+
+// handlerTimer reports the handler running longer than its timeout
+type handlerTimer struct {
+	timer *time.Timer
+	done  int32 // set when the handler returns or times out, accessed atomically
+}
+
+// startTimer starts the timer of the handler f processing the message m for the channel c,
+// nil is returned if the handler has no timeout
+func (e *event) startTimer(c *Channel, f *handler, m *protocol.Message) *handlerTimer {
+	if f.opts.Timeout <= 0 {
+		return nil
+	}
+
+	t := &handlerTimer{}
+	t.timer = time.AfterFunc(f.opts.Timeout, func() {
+		if !atomic.CompareAndSwapInt32(&t.done, 0, 1) {
+			return
+		}
+		e.logger.Warn("event.startTimer(): handler timed out:", "event", m.EventName, "timeout", f.opts.Timeout)
+		e.callErrorHandler(c, &EventError{Event: m.EventName, Args: m.Args, Err: ErrorHandlerTimeout})
+		if m.Type == protocol.MessageTypeAckRequest {
+			ackResponse := &protocol.Message{Type: protocol.MessageTypeAckResponse, AckID: m.AckID}
+			c.send(ackResponse, map[string]string{"error": ErrorHandlerTimeout.Error()})
+		}
+	})
+	return t
+}
+
+// stop stops the timer when the handler returns, false is returned if the handler has already timed out
+func (t *handlerTimer) stop() bool {
+	if t == nil {
+		return true
+	}
+	t.timer.Stop()
+	return atomic.CompareAndSwapInt32(&t.done, 0, 1)
+}
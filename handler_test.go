@@ -0,0 +1,49 @@
+package socketio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vanti-dev/golang-socketio/transport"
+)
+
+type typedArg struct{ Name string }
+
+func TestOnTypedRejectsSystemEvents(t *testing.T) {
+	s := NewServer(nil, nil, nil)
+	for _, name := range []string{OnConnection, OnDisconnection, OnError} {
+		if err := OnTyped(s, name, func(c *Channel, arg typedArg) {}); err != ErrorTypedSystemEvent {
+			t.Errorf("OnTyped(%q) = %v, want ErrorTypedSystemEvent", name, err)
+		}
+		if _, ok := s.event.findHandler(name); ok {
+			t.Errorf("OnTyped(%q) registered the handler", name)
+		}
+	}
+}
+
+func TestOnTypedCalledForAckRequest(t *testing.T) {
+	s, ts := newTestServer(t)
+	argC := make(chan typedArg, 1)
+	if err := OnTyped(s, "typed", func(c *Channel, arg typedArg) { argC <- arg }); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(nil)
+	if err := client.Dial(websocketURL(ts), transport.DefaultWebsocketTransport()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.EmitWithAck("typed", typedArg{Name: "a"}, func(string, error) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case arg := <-argC:
+		if arg.Name != "a" {
+			t.Fatalf("handler called with %+v, want Name a", arg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the typed handler wasn't called for the ack request")
+	}
+}
@@ -0,0 +1,48 @@
+package socketio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// errTrailingData is returned if event args contain data after the decoded value
+var errTrailingData = errors.New("invalid data after top-level value")
+
+// JSON marshals event payloads and unmarshals event args, so a faster implementation compatible
+// with encoding/json could be used, see Server.SetJSON
+type JSON interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// NumberJSON is implemented by the JSON implementations able to decode numbers into interface{} values
+// as json.Number, it's used to unmarshal event args if Server.SetUseNumber is enabled
+type NumberJSON interface {
+	UnmarshalUseNumber(data []byte, v interface{}) error
+}
+
+// StdJSON is the JSON implementation by encoding/json, it's used by default
+type StdJSON struct{}
+
+// Marshal v using json.Marshal
+func (StdJSON) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal data into v using json.Unmarshal
+func (StdJSON) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// UnmarshalUseNumber data into v like Unmarshal, but the numbers are decoded into interface{} values
+// as json.Number
+func (StdJSON) UnmarshalUseNumber(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	// the data should contain a single JSON value, as for json.Unmarshal
+	if _, err := decoder.Token(); err != io.EOF {
+		return errTrailingData
+	}
+	return nil
+}
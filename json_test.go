@@ -0,0 +1,58 @@
+package socketio
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/vanti-dev/golang-socketio/logging"
+)
+
+// plainJSON is a JSON implementation without NumberJSON support
+type plainJSON struct{}
+
+func (plainJSON) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (plainJSON) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// warnLogger records the warnings
+type warnLogger struct {
+	logging.Logger
+	warnings []string
+	mu       sync.Mutex
+}
+
+func (l *warnLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.mu.Lock()
+	l.warnings = append(l.warnings, msg)
+	l.mu.Unlock()
+}
+
+func TestUnmarshalUseNumber(t *testing.T) {
+	tests := []struct {
+		name       string
+		json       JSON
+		wantNumber bool
+		wantWarn   bool
+	}{
+		{"default", nil, true, false},
+		{"NumberJSON", StdJSON{}, true, false},
+		{"plain JSON", plainJSON{}, false, true},
+	}
+	for _, tt := range tests {
+		logger := &warnLogger{Logger: logging.Nop()}
+		s := NewServer(nil, nil, logger)
+		s.SetJSON(tt.json)
+		s.SetUseNumber(true)
+
+		var v interface{}
+		if err := s.event.unmarshal([]byte("12345678901234567890"), &v); err != nil {
+			t.Fatalf("%s: unmarshal() = %v", tt.name, err)
+		}
+		if _, isNumber := v.(json.Number); isNumber != tt.wantNumber {
+			t.Errorf("%s: decoded %T, want json.Number %v", tt.name, v, tt.wantNumber)
+		}
+		if warned := len(logger.warnings) > 0; warned != tt.wantWarn {
+			t.Errorf("%s: warnings %q, want warning %v", tt.name, logger.warnings, tt.wantWarn)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package socketio
+
+import "sync"
+
+// connectionLimits counts live channels in total and by the client IP address to limit them
+type connectionLimits struct {
+	sync.Mutex
+	max      int            // maximum channels in total, zero means no limit
+	maxPerIP int            // maximum channels per IP address, zero means no limit
+	total    int            // amount of channels
+	perIP    map[string]int // maps IP address to the amount of channels
+}
+
+// acquire counts a new channel from ip, returns ErrorMaxConnections or ErrorTooManyConnections
+// if the corresponding limit is reached
+func (l *connectionLimits) acquire(ip string) error {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.max > 0 && l.total >= l.max {
+		return ErrorMaxConnections
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return ErrorTooManyConnections
+	}
+	l.total++
+	l.perIP[ip]++
+	return nil
+}
+
+// release uncounts a channel from ip
+func (l *connectionLimits) release(ip string) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.total > 0 {
+		l.total--
+	}
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+		return
+	}
+	l.perIP[ip]--
+}
+
+// SetMaxConnections limits the total amount of connected channels. New connections over the limit
+// are rejected with 503 status, zero means no limit
+func (s *Server) SetMaxConnections(n int) {
+	s.connectionLimits.Lock()
+	s.connectionLimits.max = n
+	s.connectionLimits.Unlock()
+}
+
+// SetMaxConnectionsPerIP limits the amount of channels connected from a single client IP address,
+// see Channel.RemoteAddr. New connections over the limit are rejected with 429 status, zero means no limit
+func (s *Server) SetMaxConnectionsPerIP(n int) {
+	s.connectionLimits.Lock()
+	s.connectionLimits.maxPerIP = n
+	s.connectionLimits.Unlock()
+}
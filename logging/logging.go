@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Logger is a minimal leveled logger. Fields are passed as alternating keys and values
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+var (
+	log   Logger
+	logMu sync.RWMutex
+)
+
+// Log returns the global logger used by the transports created without a logger. It's the one set with SetLog,
+// or a production zap logger by default
+func Log() Logger {
+	logMu.RLock()
+	l := log
+	logMu.RUnlock()
+	if l != nil {
+		return l
+	}
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	if log == nil {
+		l, err := zap.NewProduction()
+		if err != nil {
+			log = Nop()
+		} else {
+			log = NewZap(l)
+		}
+	}
+	return log
+}
+
+// OrNop returns l, or the no-op logger if l is nil
+func OrNop(l Logger) Logger {
+	if l == nil {
+		return Nop()
+	}
+	return l
+}
+
+// SetLog sets the global logger, the loggers of the servers aren't affected
+func SetLog(l Logger) {
+	logMu.Lock()
+	log = l
+	logMu.Unlock()
+}
+
+// zapLogger adapts zap logger to the Logger interface
+type zapLogger struct {
+	base  *zap.Logger
+	sugar *zap.SugaredLogger
+}
+
+// NewZap returns a Logger backed by the zap logger l
+func NewZap(l *zap.Logger) Logger { return zapLogger{base: l, sugar: l.Sugar()} }
+
+// DebugEnabled checks whether debug messages are written
+func (l zapLogger) DebugEnabled() bool { return l.base.Core().Enabled(zap.DebugLevel) }
+
+func (l zapLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.sugar.Debugw(msg, keysAndValues...)
+}
+func (l zapLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.sugar.Infow(msg, keysAndValues...)
+}
+func (l zapLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.sugar.Warnw(msg, keysAndValues...)
+}
+func (l zapLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.sugar.Errorw(msg, keysAndValues...)
+}
+
+// nopLogger discards all messages
+type nopLogger struct{}
+
+// Nop returns a Logger which discards all messages
+func Nop() Logger { return nopLogger{} }
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// DebugEnabled is always false for the no-op logger
+func (nopLogger) DebugEnabled() bool { return false }
+
+// debugEnabler is implemented by loggers able to report whether debug messages are written
+type debugEnabler interface {
+	DebugEnabled() bool
+}
+
+// DebugEnabled checks whether the logger l writes debug messages, so the hot paths are able
+// to skip building of the message fields. Loggers not reporting it are considered enabled
+func DebugEnabled(l Logger) bool {
+	if d, ok := l.(debugEnabler); ok {
+		return d.DebugEnabled()
+	}
+	return true
+}
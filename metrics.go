@@ -0,0 +1,40 @@
+package socketio
+
+import "time"
+
+// Metrics receives the server events for monitoring, e.g. to be exported to Prometheus.
+// Methods are called synchronously, so they should be fast and safe for concurrent use
+type Metrics interface {
+	OnConnect(c *Channel)                          // a new channel connected
+	OnDisconnect(c *Channel)                       // the channel disconnected
+	OnMessageIn(c *Channel, name string)           // an event received by the channel
+	OnMessageOut(c *Channel, name string)          // a message queued to be sent by the channel
+	OnBroadcast(room, name string, recipients int) // an event broadcasted, room is empty for all channels
+	OnAckLatency(d time.Duration)                  // an ack response received in d after the request
+}
+
+// NopMetrics is a Metrics implementation which does nothing, it's used by default
+type NopMetrics struct{}
+
+func (NopMetrics) OnConnect(*Channel)              {}
+func (NopMetrics) OnDisconnect(*Channel)           {}
+func (NopMetrics) OnMessageIn(*Channel, string)    {}
+func (NopMetrics) OnMessageOut(*Channel, string)   {}
+func (NopMetrics) OnBroadcast(string, string, int) {}
+func (NopMetrics) OnAckLatency(time.Duration)      {}
+
+// SetMetrics sets the metrics receiver m, nil disables metrics
+func (s *Server) SetMetrics(m Metrics) {
+	if m == nil {
+		m = NopMetrics{}
+	}
+	s.metrics = m
+}
+
+// metrics returns the metrics receiver of the channel server, client channels have no metrics
+func (c *Channel) metrics() Metrics {
+	if c.server == nil {
+		return NopMetrics{}
+	}
+	return c.server.metrics
+}
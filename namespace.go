@@ -0,0 +1,10 @@
+package socketio
+
+// DefaultNamespace is the namespace of the channels connected without an explicit namespace
+const DefaultNamespace = "/"
+
+// roomKey identifies a room, rooms with the same name in different namespaces are distinct
+type roomKey struct {
+	namespace string
+	room      string
+}
@@ -0,0 +1,21 @@
+package socketio
+
+import "github.com/vanti-dev/golang-socketio/protocol"
+
+// SetOrderedEvents makes the events of the channels connected after the call handled one by one in the order
+// they're received, by a worker of the channel, instead of concurrently. The channel keeps reading while
+// a handler runs: up to queueSize events wait for the worker, the channel stops reading when the queue is full.
+// The inline handlers and the ack responses aren't queued. Zero disables it, it's the default
+func (s *Server) SetOrderedEvents(queueSize int) { s.eventsQueueSize = queueSize }
+
+// isOrdered checks if the message m is handled by the worker of the channel c
+func (c *Channel) isOrdered(m *protocol.Message) bool {
+	return c.eventsC != nil && (m.Type == protocol.MessageTypeEmit || m.Type == protocol.MessageTypeAckRequest)
+}
+
+// handleEvents processes the queued events of the channel in order until the queue is closed
+func (c *Channel) handleEvents(e *event) {
+	for m := range c.eventsC {
+		e.processIncoming(c, m)
+	}
+}
@@ -0,0 +1,40 @@
+package socketio
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DefaultPath is the path the socket.io clients connect to by default
+const DefaultPath = "/socket.io/"
+
+// SetPath sets the path the server is mounted at, e.g. "/chat/socket.io/" for the clients configured with
+// such path. Only the requests to the path are served then, the others are responded with 404 Not Found.
+// By default requests to any path are served, so the server may be mounted at any prefix
+func (s *Server) SetPath(path string) {
+	if path == "" {
+		s.path = ""
+		return
+	}
+	s.path = "/" + strings.Trim(path, "/") + "/"
+	if s.path == "//" {
+		s.path = "/"
+	}
+}
+
+// Path returns the path the server is mounted at, it's DefaultPath if not set
+func (s *Server) Path() string {
+	if s.path == "" {
+		return DefaultPath
+	}
+	return s.path
+}
+
+// matchesPath checks if the request r is made to the path the server is mounted at, with or without
+// the trailing slash
+func (s *Server) matchesPath(r *http.Request) bool {
+	if s.path == "" {
+		return true
+	}
+	return r.URL.Path == s.path || r.URL.Path == strings.TrimSuffix(s.path, "/")
+}
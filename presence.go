@@ -0,0 +1,109 @@
+package socketio
+
+import "sort"
+
+// SetUser associates the channel with the application user id, e.g. after the authentication, so the channel
+// is found by Server.ChannelsForUser. A user may have several channels, e.g. browser tabs. Empty id removes
+// the association, it's removed on disconnection too
+func (c *Channel) SetUser(id string) error {
+	if c.server == nil {
+		return ErrorServerNotSet
+	}
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		return upgraded.SetUser(id)
+	}
+
+	c.server.usersMu.Lock()
+	c.server.setUser(c, id)
+	c.server.usersMu.Unlock()
+
+	// the channel disconnected meanwhile could have been cleaned up already
+	if !c.IsAlive() {
+		c.server.removeUser(c)
+		return ErrorChannelClosed
+	}
+	return nil
+}
+
+// User returns the application user id associated with the channel, it's empty if not set
+func (c *Channel) User() string {
+	if c.server == nil {
+		return ""
+	}
+	if upgraded := c.upgradedChannel(); upgraded != nil {
+		return upgraded.User()
+	}
+
+	c.server.usersMu.RLock()
+	defer c.server.usersMu.RUnlock()
+	return c.user
+}
+
+// IsOnline checks if the user has any connected channel
+func (s *Server) IsOnline(userID string) bool {
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+	return len(s.users[userID]) > 0
+}
+
+// ChannelsForUser returns the connected channels of the user sorted by their ids
+func (s *Server) ChannelsForUser(userID string) []*Channel {
+	s.usersMu.RLock()
+	channels := make([]*Channel, 0, len(s.users[userID]))
+	for c := range s.users[userID] {
+		channels = append(channels, c)
+	}
+	s.usersMu.RUnlock()
+
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Id() < channels[j].Id() })
+	return channels
+}
+
+// setUser associates the channel c with the user id replacing the previous one, usersMu should be locked
+func (s *Server) setUser(c *Channel, id string) {
+	if c.user != "" {
+		delete(s.users[c.user], c)
+		if len(s.users[c.user]) == 0 {
+			delete(s.users, c.user)
+		}
+	}
+
+	c.user = id
+	if id == "" {
+		return
+	}
+	if _, ok := s.users[id]; !ok {
+		s.users[id] = make(map[*Channel]struct{})
+	}
+	s.users[id][c] = struct{}{}
+}
+
+// removeUser removes the association of the channel c with its user
+func (s *Server) removeUser(c *Channel) {
+	s.usersMu.Lock()
+	s.setUser(c, "")
+	s.usersMu.Unlock()
+}
+
+// EmitToUsers emits an event with payload to the connected channels of the given users, the offline users
+// are skipped. The event is emitted once per channel, even if the user is listed several times.
+// The users are tracked per node, so only the channels of this node receive the event
+func (s *Server) EmitToUsers(userIDs []string, name string, payload interface{}) {
+	s.usersMu.RLock()
+	visited := make(map[*Channel]struct{})
+	recipients := make([]*Channel, 0)
+	for _, userID := range userIDs {
+		for c := range s.users[userID] {
+			if _, ok := visited[c]; ok {
+				continue
+			}
+			visited[c] = struct{}{}
+			if c.IsAlive() {
+				recipients = append(recipients, c)
+			}
+		}
+	}
+	s.usersMu.RUnlock()
+
+	go s.emitEach(recipients, name, payload)
+}
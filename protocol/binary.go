@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// binaryMessagePrefix is the engine.io message type prepended to binary frames
+const binaryMessagePrefix = 4
+
+var (
+	ErrorWrongAttachment = errors.New("wrong attachment")
+)
+
+// IsBinary checks that the encoded packet is followed by binary attachments
+func IsBinary(packet string) bool {
+	return strings.HasPrefix(packet, messageBinary) || strings.HasPrefix(packet, messageBinaryACK)
+}
+
+// Placeholder returns an args representation of the attachment with the given number
+func Placeholder(num int) string { return fmt.Sprintf(`{"_placeholder":true,"num":%d}`, num) }
+
+// EncodeAttachment returns the attachment a as an engine.io binary frame
+func EncodeAttachment(a []byte) []byte { return append([]byte{binaryMessagePrefix}, a...) }
+
+// DecodeAttachment returns an attachment from the given engine.io binary frame
+func DecodeAttachment(frame []byte) ([]byte, error) {
+	if len(frame) == 0 || frame[0] != binaryMessagePrefix {
+		return nil, ErrorWrongAttachment
+	}
+	return frame[1:], nil
+}
+
+// ExtractAttachments replaces the []byte values of v with placeholders, appending the values to attachments.
+// The values nested into []interface{} and map[string]interface{} are replaced too, v itself is not modified
+func ExtractAttachments(v interface{}, attachments [][]byte) (interface{}, [][]byte) {
+	switch v := v.(type) {
+	case []byte:
+		placeholder := map[string]interface{}{"_placeholder": true, "num": len(attachments)}
+		return placeholder, append(attachments, v)
+	case []interface{}:
+		replaced := make([]interface{}, len(v))
+		for i := range v {
+			replaced[i], attachments = ExtractAttachments(v[i], attachments)
+		}
+		return replaced, attachments
+	case map[string]interface{}:
+		replaced := make(map[string]interface{}, len(v))
+		for key := range v {
+			replaced[key], attachments = ExtractAttachments(v[key], attachments)
+		}
+		return replaced, attachments
+	}
+	return v, attachments
+}
+
+// FillPlaceholders replaces the attachment placeholders in args of the message m with the attachments.
+// Attachments are substituted as base64 encoded strings, so they can be unmarshalled into []byte
+func FillPlaceholders(m *Message) error {
+	var args interface{}
+	if err := json.Unmarshal([]byte("["+m.Args+"]"), &args); err != nil {
+		return err
+	}
+
+	args, err := fillPlaceholders(args, m.Attachments)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	m.Args = string(b[1 : len(b)-1])
+	return nil
+}
+
+// fillPlaceholders walks the decoded args v replacing placeholders with attachments
+func fillPlaceholders(v interface{}, attachments [][]byte) (interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		for i := range v {
+			replaced, err := fillPlaceholders(v[i], attachments)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = replaced
+		}
+	case map[string]interface{}:
+		if isPlaceholder, _ := v["_placeholder"].(bool); isPlaceholder {
+			num, ok := v["num"].(float64)
+			if !ok || int(num) < 0 || int(num) >= len(attachments) {
+				return nil, ErrorWrongAttachment
+			}
+			return attachments[int(num)], nil
+		}
+
+		for key := range v {
+			replaced, err := fillPlaceholders(v[key], attachments)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = replaced
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,38 @@
+package protocol
+
+// Codec encodes socket.io messages into the wire format and decodes them back.
+// Message args are represented as JSON, unless the codec implements ArgsCodec.
+// The codec implementing BinaryCodec encodes the socket.io packets into binary messages
+type Codec interface {
+	Encode(m *Message) (string, error)
+	Decode(data string) (*Message, error)
+}
+
+// JSONCodec is the default codec, implementing the socket.io JSON based protocol format
+type JSONCodec struct{}
+
+// Encode a socket.io message m to the protocol format
+func (JSONCodec) Encode(m *Message) (string, error) { return Encode(m) }
+
+// Decode the given data string into a Message
+func (JSONCodec) Decode(data string) (*Message, error) { return Decode(data) }
+
+// ArgsCodec is implemented by the codecs encoding the message args in their own format instead of JSON,
+// Message.Args holds the args encoded by MarshalArgs then. The binary args values are encoded by the codec
+// itself, they aren't sent as attachments
+type ArgsCodec interface {
+	// MarshalArgs encodes the args values
+	MarshalArgs(args []interface{}) (string, error)
+
+	// UnmarshalArgs decodes the encoded args data into the values pointed by args positionally. The extra encoded
+	// args are ignored, the values of the missing ones are left untouched
+	UnmarshalArgs(data string, args ...interface{}) error
+}
+
+// BinaryCodec is implemented by the codecs with a binary wire format, e.g. msgpack. The socket.io packets encoded
+// by EncodeBinary are sent as engine.io binary messages, the binary messages received are decoded by DecodeBinary.
+// The engine.io packets, e.g. the open and the heartbeat ones, are always text
+type BinaryCodec interface {
+	EncodeBinary(m *Message) ([]byte, error)
+	DecodeBinary(data []byte) (*Message, error)
+}
@@ -11,6 +11,7 @@ const (
 	MessageTypeAckResponse        // ack response
 	MessageTypeUpgrade            // upgrade message
 	MessageTypeBlank              // blank message
+	MessageTypeConnectErr         // socket.io v3/v4 namespace connection refusal
 )
 
 // Message represents socket.io message
@@ -20,4 +21,8 @@ type Message struct {
 	EventName string
 	Args      string
 	Source    string
+	Namespace string // namespace of the connect packets, empty means the default one
+
+	// Attachments are binary attachments of the message, they are referenced by placeholders from Args
+	Attachments [][]byte
 }
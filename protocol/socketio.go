@@ -9,20 +9,23 @@ import (
 )
 
 const (
-	MessageOpen        = "0"
-	MessageClose       = "1"
-	MessagePing        = "2"
-	MessagePingProbe   = "2probe"
-	MessagePongProbe   = "3probe"
-	MessagePong        = "3"
-	messageMSG         = "4"
-	MessageEmpty       = "40"
-	messageCloseClient = "41"
-	messageCommon      = "42"
-	messageACK         = "43"
-	MessageUpgrade     = "5"
-	MessageBlank       = "6"
-	MessageStub        = "stub"
+	MessageOpen       = "0"
+	MessageClose      = "1"
+	MessagePing       = "2"
+	MessagePingProbe  = "2probe"
+	MessagePongProbe  = "3probe"
+	MessagePong       = "3"
+	messageMSG        = "4"
+	MessageEmpty      = "40"
+	MessageDisconnect = "41"
+	messageCommon     = "42"
+	messageACK        = "43"
+	messageConnectErr = "44"
+	messageBinary     = "45"
+	messageBinaryACK  = "46"
+	MessageUpgrade    = "5"
+	MessageBlank      = "6"
+	MessageStub       = "stub"
 )
 
 var (
@@ -30,6 +33,20 @@ var (
 	ErrorWrongPacket      = errors.New("wrong packet")
 )
 
+// MaxAttachments is the maximum attachments count of the received binary packet, the packet declaring more
+// attachments is rejected with ErrorWrongPacket
+var MaxAttachments = 64
+
+// IsEvent checks if the packet is an event or an ack packet, including the binary ones
+func IsEvent(packet string) bool {
+	for _, prefix := range []string{messageCommon, messageACK, messageBinary, messageBinaryACK} {
+		if strings.HasPrefix(packet, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func typeToText(mType int) (string, error) {
 	codesToNames := map[int]string{
 		MessageTypeOpen:        MessageOpen,
@@ -40,6 +57,7 @@ func typeToText(mType int) (string, error) {
 		MessageTypeEmit:        messageCommon,
 		MessageTypeAckRequest:  messageCommon,
 		MessageTypeAckResponse: messageACK,
+		MessageTypeConnectErr:  messageConnectErr,
 	}
 	mName, exists := codesToNames[mType]
 	if !exists {
@@ -48,6 +66,19 @@ func typeToText(mType int) (string, error) {
 	return mName, nil
 }
 
+// withAttachments returns the binary packet type with attachments count for the given packet type
+func withAttachments(mType string, count int) string {
+	switch mType {
+	case messageCommon:
+		mType = messageBinary
+	case messageACK:
+		mType = messageBinaryACK
+	default:
+		return mType
+	}
+	return mType + strconv.Itoa(count) + "-"
+}
+
 // Encode a socket.io message m to the protocol format
 func Encode(m *Message) (string, error) {
 	result, err := typeToText(m.Type)
@@ -55,9 +86,19 @@ func Encode(m *Message) (string, error) {
 		return "", err
 	}
 
+	if len(m.Attachments) > 0 {
+		result = withAttachments(result, len(m.Attachments))
+	}
+
 	switch m.Type {
-	case MessageTypeEmpty, MessageTypePing, MessageTypePong:
+	case MessageTypePing, MessageTypePong:
 		return result, nil
+	case MessageTypeEmpty, MessageTypeConnectErr:
+		// the connect packets carry the namespace and the payload, e.g. the auth data or the connect acknowledgement
+		if m.Namespace != "" && m.Namespace != "/" {
+			result += m.Namespace + ","
+		}
+		return result + m.Args, nil
 	case MessageTypeAckRequest:
 		result += strconv.Itoa(m.AckID)
 	case MessageTypeAckResponse:
@@ -108,17 +149,56 @@ func getMessageType(data string) (int, error) {
 		switch data[0:2] {
 		case MessageEmpty:
 			return MessageTypeEmpty, nil
-		case messageCloseClient:
+		case messageConnectErr:
+			return MessageTypeConnectErr, nil
+		case MessageDisconnect:
 			return MessageTypeClose, nil
-		case messageCommon:
+		case messageCommon, messageBinary:
 			return MessageTypeAckRequest, nil
-		case messageACK:
+		case messageACK, messageBinaryACK:
 			return MessageTypeAckResponse, nil
 		}
 	}
 	return 0, ErrorWrongMessageType
 }
 
+// getAttachments extracts an attachments count of the binary packet, and returns the text
+// rewritten as the appropriate non-binary packet
+func getAttachments(text string) (count int, restText string, err error) {
+	pos := strings.IndexByte(text[2:], '-')
+	if pos == -1 {
+		return 0, "", ErrorWrongPacket
+	}
+
+	count, err = strconv.Atoi(text[2 : 2+pos])
+	if err != nil {
+		return 0, "", err
+	}
+	if count < 0 || count > MaxAttachments {
+		return 0, "", ErrorWrongPacket
+	}
+
+	switch text[0:2] {
+	case messageBinary:
+		restText = messageCommon
+	case messageBinaryACK:
+		restText = messageACK
+	}
+
+	return count, restText + text[2+pos+1:], nil
+}
+
+// getNamespace extracts the namespace of the connect packet text, it's empty for the default namespace
+func getNamespace(text string) (namespace, restText string) {
+	if !strings.HasPrefix(text, "/") {
+		return "", text
+	}
+	if i := strings.IndexByte(text, ','); i != -1 {
+		return text[:i], text[i+1:]
+	}
+	return text, ""
+}
+
 // getAck extracts an id of the current packet if present
 func getAck(text string) (ackId int, restText string, err error) {
 	if len(text) < 4 {
@@ -182,13 +262,26 @@ func Decode(data string) (*Message, error) {
 	}
 
 	switch m.Type {
-	case MessageTypeUpgrade, MessageTypeClose, MessageTypePing, MessageTypePong, MessageTypeEmpty, MessageTypeBlank:
+	case MessageTypeUpgrade, MessageTypeClose, MessageTypePing, MessageTypePong, MessageTypeBlank:
+		return m, nil
+	case MessageTypeEmpty, MessageTypeConnectErr:
+		m.Namespace, m.Args = getNamespace(data[2:])
 		return m, nil
 	case MessageTypeOpen:
 		m.Args = data[1:]
 		return m, nil
 	}
 
+	switch data[0:2] {
+	case messageBinary, messageBinaryACK:
+		var count int
+		count, data, err = getAttachments(data)
+		if err != nil {
+			return nil, err
+		}
+		m.Attachments = make([][]byte, count)
+	}
+
 	ack, rest, err := getAck(data)
 	m.AckID = ack
 	if m.Type == MessageTypeAckResponse {
@@ -0,0 +1,23 @@
+package protocol
+
+import "testing"
+
+func TestDecodeRejectsAttachmentsCount(t *testing.T) {
+	for _, data := range []string{
+		`459999999999999999-["a"]`,
+		`451000000000-["a"]`,
+		`4665-["a"]`,
+	} {
+		if _, err := Decode(data); err != ErrorWrongPacket {
+			t.Errorf("Decode(%s) err = %v, want %v", data, err, ErrorWrongPacket)
+		}
+	}
+
+	m, err := Decode(`451-["a",{"_placeholder":true,"num":0}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Attachments) != 1 {
+		t.Fatalf("attachments = %d, want 1", len(m.Attachments))
+	}
+}
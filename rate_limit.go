@@ -0,0 +1,154 @@
+package socketio
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vanti-dev/golang-socketio/protocol"
+)
+
+// RateLimitAction describes what happens to an incoming event exceeding the rate limit
+type RateLimitAction int
+
+const (
+	RateLimitDrop       RateLimitAction = iota // the event is dropped
+	RateLimitDisconnect                        // the channel is disconnected
+)
+
+// rateLimit represents a limit of incoming events, zero events per second means no limit
+type rateLimit struct {
+	eventsPerSecond int
+	burst           int
+}
+
+// rateLimits holds the server rate limits of incoming events of every channel
+type rateLimits struct {
+	sync.RWMutex
+	common rateLimit            // limit shared by all the events without the specific limit
+	events map[string]rateLimit // maps event name to its specific limit
+	action RateLimitAction
+}
+
+// limitOf returns the limit for the given event name and the key of the bucket it should be counted in
+func (l *rateLimits) limitOf(name string) (limit rateLimit, key string, action RateLimitAction) {
+	l.RLock()
+	defer l.RUnlock()
+
+	if eventLimit, ok := l.events[name]; ok {
+		return eventLimit, name, l.action
+	}
+	return l.common, "", l.action
+}
+
+// tokenBucket counts incoming events according to the token bucket algorithm
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the bucket refilled by the given limit, returns false if there is no token
+func (b *tokenBucket) allow(limit rateLimit, now time.Time) bool {
+	burst := float64(limit.burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * float64(limit.eventsPerSecond)
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// SetRateLimit limits incoming events of every channel to eventsPerSecond with bursts up to burst events.
+// The limit is shared by all the events without the specific limit set with SetEventRateLimit,
+// zero eventsPerSecond means no limit
+func (s *Server) SetRateLimit(eventsPerSecond int, burst int) {
+	s.rateLimits.Lock()
+	s.rateLimits.common = rateLimit{eventsPerSecond: eventsPerSecond, burst: burst}
+	s.rateLimits.Unlock()
+}
+
+// SetEventRateLimit overrides the rate limit for the event with the given name, such events are counted
+// separately. Zero eventsPerSecond means no limit for the event
+func (s *Server) SetEventRateLimit(name string, eventsPerSecond int, burst int) {
+	s.rateLimits.Lock()
+	s.rateLimits.events[name] = rateLimit{eventsPerSecond: eventsPerSecond, burst: burst}
+	s.rateLimits.Unlock()
+}
+
+// SetRateLimitAction sets what happens to an incoming event exceeding the rate limit, it's dropped by default
+func (s *Server) SetRateLimitAction(action RateLimitAction) {
+	s.rateLimits.Lock()
+	s.rateLimits.action = action
+	s.rateLimits.Unlock()
+}
+
+// inheritBuckets copies the rate limiting buckets of the channel replaced at the transport upgrade,
+// so the upgrade doesn't reset the limits. The buckets of the events already received by c are kept
+func (c *Channel) inheritBuckets(replaced *Channel) {
+	replaced.bucketsMu.Lock()
+	defer replaced.bucketsMu.Unlock()
+	c.bucketsMu.Lock()
+	defer c.bucketsMu.Unlock()
+
+	for key, bucket := range replaced.buckets {
+		if _, ok := c.buckets[key]; !ok {
+			copied := *bucket
+			c.buckets[key] = &copied
+		}
+	}
+}
+
+// allowEvent checks if the incoming event with the given name is within the rate limit.
+// If it's not, the channel is disconnected if it is configured so
+func (c *Channel) allowEvent(name string) bool {
+	if c.server == nil {
+		return true
+	}
+
+	limit, key, action := c.server.rateLimits.limitOf(name)
+	if limit.eventsPerSecond <= 0 {
+		return true
+	}
+
+	c.bucketsMu.Lock()
+	bucket, ok := c.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{}
+		c.buckets[key] = bucket
+	}
+	allowed := bucket.allow(limit, time.Now())
+	c.bucketsMu.Unlock()
+
+	if !allowed {
+		c.logger.Info("Channel.allowEvent() rate limit exceeded:", "sid", c.Id(), "event", name)
+		if action == RateLimitDisconnect {
+			go c.disconnect(DisconnectReasonRateLimit)
+		}
+	}
+	return allowed
+}
+
+// allowIncoming checks the rate limit of the incoming event message m before it's dispatched to the handler,
+// so the dropped events don't spawn the handler goroutines. The dropped events are still counted by the metrics
+func (c *Channel) allowIncoming(m *protocol.Message) bool {
+	if m.Type != protocol.MessageTypeEmit && m.Type != protocol.MessageTypeAckRequest {
+		return true
+	}
+	if c.allowEvent(m.EventName) {
+		return true
+	}
+	c.metrics().OnMessageIn(c, m.EventName)
+	return false
+}
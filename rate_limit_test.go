@@ -0,0 +1,55 @@
+package socketio
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vanti-dev/golang-socketio/transport"
+)
+
+// feedConn is a fakeConn receiving the messages sent to feedC
+type feedConn struct {
+	*fakeConn
+	feedC chan string
+}
+
+func (f *feedConn) GetMessage() (string, error) {
+	select {
+	case m := <-f.feedC:
+		return m, nil
+	case <-f.closedC:
+		return "", transport.ErrClosed
+	}
+}
+
+func TestRateLimitCheckedBeforeDispatch(t *testing.T) {
+	s := NewServer(nil, nil, nil)
+	s.SetRateLimit(1, 1)
+	var calls int32
+	s.On("event", func(c *Channel, v string) { atomic.AddInt32(&calls, 1) })
+
+	conn := &feedConn{fakeConn: newFakeConn(), feedC: make(chan string)}
+	c := &Channel{conn: conn, server: s, connHeader: ConnectionHeader{Sid: "sid"}, logger: s.logger}
+	c.init()
+	go c.inLoop(s.event)
+	defer conn.Close()
+
+	for i := 0; i < 3; i++ {
+		conn.feedC <- `42["event","v"]`
+	}
+
+	// the third message is read after the first two are checked, the dropped one spawns no handler goroutine
+	c.bucketsMu.Lock()
+	bucket, ok := c.buckets[""]
+	taken := ok && bucket.tokens < 1
+	c.bucketsMu.Unlock()
+	if !taken {
+		t.Fatal("the tokens aren't taken before the events are dispatched")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("handler calls = %d, want 1", n)
+	}
+}
@@ -0,0 +1,195 @@
+package socketio
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/vanti-dev/golang-socketio/logging"
+	"github.com/vanti-dev/golang-socketio/transport"
+)
+
+const (
+	reconnectDefaultInitialDelay = time.Second
+	reconnectDefaultMaxDelay     = 30 * time.Second
+	reconnectDefaultJitter       = 0.5
+)
+
+var (
+	ErrorNotConnected = errors.New("client is not connected")
+	ErrorClientClosed = errors.New("client is closed")
+)
+
+// ReconnectOptions represents parameters of the client reconnection
+type ReconnectOptions struct {
+	Transport transport.Transport
+
+	MaxAttempts  int           // maximum reconnection attempts in a row, 0 means unlimited
+	InitialDelay time.Duration // delay before the first reconnection attempt
+	MaxDelay     time.Duration // upper bound of the delay between attempts
+	Jitter       float64       // randomization factor of the delay, from 0 to 1
+
+	// BufferEmits enables buffering of emits while disconnected, they are replayed on reconnect
+	BufferEmits bool
+}
+
+// bufferedEmit represents an emit made while the client was disconnected
+type bufferedEmit struct {
+	name    string
+	payload interface{}
+}
+
+// ReconnectingClient represents socket.io client which reconnects on unexpected disconnection
+// using exponential backoff. OnReconnect handler fires after every successful reconnection
+type ReconnectingClient struct {
+	*event
+
+	url  string
+	opts ReconnectOptions
+
+	client   *Client
+	closed   bool
+	buffered []bufferedEmit
+	clientMu sync.Mutex
+}
+
+// NewReconnectingClient returns a client, handlers should be registered on it before Dial
+func NewReconnectingClient(logger logging.Logger) *ReconnectingClient {
+	rc := &ReconnectingClient{event: &event{logger: logging.OrNop(logger)}}
+	rc.event.init()
+	rc.event.onDisconnection = rc.onDisconnection
+	return rc
+}
+
+// Dial connects to server with the given url, the connection is restored on unexpected disconnection
+func (rc *ReconnectingClient) Dial(url string, opts ReconnectOptions) error {
+	if opts.InitialDelay <= 0 {
+		opts.InitialDelay = reconnectDefaultInitialDelay
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = reconnectDefaultMaxDelay
+	}
+	if opts.Jitter < 0 || opts.Jitter > 1 {
+		opts.Jitter = reconnectDefaultJitter
+	}
+
+	rc.clientMu.Lock()
+	defer rc.clientMu.Unlock()
+
+	rc.url, rc.opts, rc.closed = url, opts, false
+
+	client, err := dial(url, opts.Transport, rc.event)
+	if err != nil {
+		return err
+	}
+
+	rc.client = client
+	return nil
+}
+
+// Emit an asynchronous event with the given name and payload. While disconnected the event is buffered
+// if it's enabled by options, otherwise ErrorNotConnected is returned
+func (rc *ReconnectingClient) Emit(name string, payload interface{}) error {
+	rc.clientMu.Lock()
+	defer rc.clientMu.Unlock()
+
+	if rc.closed {
+		return ErrorClientClosed
+	}
+
+	if rc.client == nil || !rc.client.IsAlive() {
+		if !rc.opts.BufferEmits {
+			return ErrorNotConnected
+		}
+		rc.buffered = append(rc.buffered, bufferedEmit{name: name, payload: payload})
+		return nil
+	}
+
+	return rc.client.Emit(name, payload)
+}
+
+// Channel returns the channel of the current connection, or nil if it was never established
+func (rc *ReconnectingClient) Channel() *Channel {
+	rc.clientMu.Lock()
+	defer rc.clientMu.Unlock()
+
+	if rc.client == nil {
+		return nil
+	}
+	return rc.client.Channel()
+}
+
+// Close the client connection, it won't be restored anymore
+func (rc *ReconnectingClient) Close() {
+	rc.clientMu.Lock()
+	rc.closed = true
+	client := rc.client
+	rc.clientMu.Unlock()
+
+	if client != nil {
+		client.Close()
+	}
+}
+
+// onDisconnection starts reconnecting, it's fired while the channel is being closed, so the check
+// of the disconnection is performed in a separate go-routine
+func (rc *ReconnectingClient) onDisconnection(c *Channel) { go rc.reconnect(c) }
+
+// reconnect dials the server until succeeded, closed or attempts are exhausted,
+// if the connection of the channel c was the current one and it was lost unexpectedly
+func (rc *ReconnectingClient) reconnect(c *Channel) {
+	rc.clientMu.Lock()
+	lost := !rc.closed && rc.client != nil && rc.client.Channel() == c
+	rc.clientMu.Unlock()
+	if !lost {
+		return
+	}
+
+	for attempt := 0; rc.opts.MaxAttempts == 0 || attempt < rc.opts.MaxAttempts; attempt++ {
+		delay := rc.delay(attempt)
+		rc.logger.Debug("ReconnectingClient.reconnect() waiting:", "attempt", attempt, "delay", delay)
+		time.Sleep(delay)
+
+		client, err := dial(rc.url, rc.opts.Transport, rc.event)
+		if err != nil {
+			rc.logger.Debug("ReconnectingClient.reconnect() failed:", "err", err)
+			continue
+		}
+
+		rc.clientMu.Lock()
+		if rc.closed {
+			rc.clientMu.Unlock()
+			client.Close()
+			return
+		}
+
+		rc.client = client
+		buffered := rc.buffered
+		rc.buffered = nil
+		rc.clientMu.Unlock()
+
+		for _, emit := range buffered {
+			if err := client.Emit(emit.name, emit.payload); err != nil {
+				rc.logger.Warn("ReconnectingClient.reconnect() failed to replay emit:", "err", err)
+			}
+		}
+
+		rc.callHandler(client.Channel(), OnReconnect)
+		return
+	}
+
+	rc.logger.Warn("ReconnectingClient.reconnect() gave up reconnecting", "attempts", rc.opts.MaxAttempts)
+}
+
+// delay returns a randomized exponential backoff delay for the given attempt
+func (rc *ReconnectingClient) delay(attempt int) time.Duration {
+	delay := float64(rc.opts.InitialDelay) * math.Pow(2, float64(attempt))
+	if delay > float64(rc.opts.MaxDelay) {
+		delay = float64(rc.opts.MaxDelay)
+	}
+
+	delay += delay * rc.opts.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
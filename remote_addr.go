@@ -0,0 +1,82 @@
+package socketio
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const headerRealIP = "X-Real-IP"
+
+// SetTrustedProxies sets the proxies which are trusted to pass the client IP address in X-Forwarded-For
+// and X-Real-IP headers. Proxies are given as CIDRs or single IP addresses. Headers are ignored by
+// Channel.RemoteAddr if no proxies are trusted
+func (s *Server) SetTrustedProxies(proxies ...string) error {
+	trusted := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy IP address %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy CIDR: %w", err)
+		}
+		trusted = append(trusted, ipNet)
+	}
+
+	s.trustedProxies = trusted
+	return nil
+}
+
+// isTrustedProxy checks if the given ip belongs to the trusted proxies
+func (s *Server) isTrustedProxy(ip net.IP) bool {
+	for _, ipNet := range s.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the client IP address for the request from remoteAddr with header.
+// Forwarding headers are respected only if the request comes from the trusted proxy
+func (s *Server) remoteIP(remoteAddr string, header http.Header) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !s.isTrustedProxy(ip) {
+		return host
+	}
+
+	// the rightmost address which is not a trusted proxy is the client one
+	forwarded := strings.Split(strings.Join(header.Values(headerForward), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		forwardedIP := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if forwardedIP == nil {
+			break
+		}
+		ip = forwardedIP
+		if !s.isTrustedProxy(ip) {
+			return ip.String()
+		}
+	}
+
+	if realIP := net.ParseIP(strings.TrimSpace(header.Get(headerRealIP))); realIP != nil {
+		return realIP.String()
+	}
+	return ip.String()
+}
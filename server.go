@@ -1,18 +1,20 @@
 package socketio
 
 import (
-	"bytes"
-	"crypto/md5"
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"go.uber.org/zap"
-	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/mtfelian/synced"
+	"go.uber.org/zap"
+
+	"github.com/vanti-dev/golang-socketio/logging"
 	"github.com/vanti-dev/golang-socketio/protocol"
 	"github.com/vanti-dev/golang-socketio/transport"
 )
@@ -20,24 +22,61 @@ import (
 var (
 	ErrorServerNotSet       = errors.New("server was not set")
 	ErrorConnectionNotFound = errors.New("connection not found")
+	ErrorServerShutdown     = errors.New("server is shutting down")
+	ErrorTooManyConnections = errors.New("too many connections")
+	ErrorMaxConnections     = errors.New("maximum connections reached")
+	ErrorTransportDisabled  = errors.New("transport is disabled")
 )
 
+// defaultBroadcastConcurrency is the default maximum amount of channels emitted to concurrently by a broadcast
+const defaultBroadcastConcurrency = 64
+
 // Server represents a socket.io server instance
 type Server struct {
 	*event
 	http.Handler
 
-	channels   map[string]map[*Channel]struct{} // maps room name to map of channels to an empty struct
-	rooms      map[*Channel]map[string]struct{} // maps channel to map of room names to an empty struct
-	channelsMu sync.RWMutex
+	adapter Adapter
+
+	onRoomJoin  func(c *Channel, room string)
+	onRoomLeave func(c *Channel, room string)
+	roomHooksMu sync.RWMutex
+
+	onUpgrade   func(c *Channel)
+	onUpgradeMu sync.RWMutex
+
+	users   map[string]map[*Channel]struct{} // maps user id to map of its channels to an empty struct
+	usersMu sync.RWMutex
 
-	sids   map[string]*Channel // maps channel id to channel
-	sidsMu sync.RWMutex
+	sids        map[string]*Channel // maps channel id to channel
+	pendingSids map[string]struct{} // ids reserved for the channels being connected
+	sidLength   int
+	sidsMu      sync.RWMutex
+
+	connectionLimits connectionLimits
+	rateLimits       rateLimits
 
 	websocket *transport.WebsocketTransport
 	polling   *transport.PollingTransport
 
-	logger *zap.Logger
+	codec                protocol.Codec
+	broadcastConcurrency int
+	outBufferSize        int
+	maxOutboxSize        int
+	eventsQueueSize      int
+	idleTimeout          time.Duration
+	pingInterval         time.Duration
+	pingTimeout          time.Duration
+	handshakeMu          sync.RWMutex
+	noUpgrades           bool
+	openSequenceHook     func(h *ConnectionHeader, c *Channel)
+	ackTimeout           time.Duration
+	metrics              Metrics
+	trustedProxies       []*net.IPNet
+	path                 string // empty means requests to any path are served
+	shuttingDown         synced.Flag
+
+	logger logging.Logger
 }
 
 // DefaultServer creates a new socket.io server with default params
@@ -46,17 +85,29 @@ func DefaultServer() (*Server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("couldn't create logger: %w", err)
 	}
-	return NewServer(transport.DefaultWebsocketTransport(), transport.DefaultPollingTransport(), logger), nil
+	return NewServer(transport.DefaultWebsocketTransport(), transport.DefaultPollingTransport(), logging.NewZap(logger)), nil
 }
 
-// NewServer create a new socket.io server with custom transports
-func NewServer(wsTransport *transport.WebsocketTransport, pollingTransport *transport.PollingTransport, logger *zap.Logger) *Server {
+// NewServer create a new socket.io server with custom transports. Nil transport disables it, the requests using it
+// are rejected. The logger is used by the server and its channels only, the global one isn't changed.
+// Nil logger disables logging. The optional codec replaces the JSON one, see SetCodec
+func NewServer(wsTransport *transport.WebsocketTransport, pollingTransport *transport.PollingTransport, logger logging.Logger, codec ...protocol.Codec) *Server {
+	logger = logging.OrNop(logger)
 	s := &Server{
-		websocket: wsTransport,
-		polling:   pollingTransport,
-		channels:  make(map[string]map[*Channel]struct{}),
-		rooms:     make(map[*Channel]map[string]struct{}),
-		sids:      make(map[string]*Channel),
+		websocket:   wsTransport,
+		polling:     pollingTransport,
+		users:       make(map[string]map[*Channel]struct{}),
+		sids:        make(map[string]*Channel),
+		pendingSids: make(map[string]struct{}),
+		connectionLimits: connectionLimits{
+			perIP: make(map[string]int),
+		},
+		rateLimits: rateLimits{
+			events: make(map[string]rateLimit),
+		},
+		codec:                protocol.JSONCodec{},
+		metrics:              NopMetrics{},
+		broadcastConcurrency: defaultBroadcastConcurrency,
 		event: &event{
 			onConnection:    onConnection,
 			onDisconnection: onDisconnection,
@@ -64,10 +115,179 @@ func NewServer(wsTransport *transport.WebsocketTransport, pollingTransport *tran
 		},
 		logger: logger,
 	}
+	if len(codec) > 0 && codec[0] != nil {
+		s.codec = codec[0]
+	}
+	s.adapter = NewMemoryAdapter(s)
 	s.event.init()
 	return s
 }
 
+// OnRoomJoin registers a function fired after a channel joined a room
+func (s *Server) OnRoomJoin(f func(c *Channel, room string)) {
+	s.roomHooksMu.Lock()
+	s.onRoomJoin = f
+	s.roomHooksMu.Unlock()
+}
+
+// OnRoomLeave registers a function fired after a channel left a room
+func (s *Server) OnRoomLeave(f func(c *Channel, room string)) {
+	s.roomHooksMu.Lock()
+	s.onRoomLeave = f
+	s.roomHooksMu.Unlock()
+}
+
+// OnUpgrade registers a function fired after the polling channel is upgraded to the websocket one, e.g. to re-send
+// the buffered state. It's called with the upgraded channel replacing the polling one, it has the same id,
+// rooms and user
+func (s *Server) OnUpgrade(f func(c *Channel)) {
+	s.onUpgradeMu.Lock()
+	s.onUpgrade = f
+	s.onUpgradeMu.Unlock()
+}
+
+// upgradeHook returns the function fired after the channel upgrade
+func (s *Server) upgradeHook() func(c *Channel) {
+	s.onUpgradeMu.RLock()
+	defer s.onUpgradeMu.RUnlock()
+	return s.onUpgrade
+}
+
+// roomHooks returns the functions fired after a channel joined and left a room
+func (s *Server) roomHooks() (onRoomJoin, onRoomLeave func(c *Channel, room string)) {
+	s.roomHooksMu.RLock()
+	defer s.roomHooksMu.RUnlock()
+	return s.onRoomJoin, s.onRoomLeave
+}
+
+// SetCheckOrigin sets the origin checking function f for both websocket and polling transports,
+// see transport.OriginAllowlist
+func (s *Server) SetCheckOrigin(f func(r *http.Request) bool) {
+	if s.websocket != nil {
+		s.websocket.CheckOriginHandler = f
+	}
+	if s.polling != nil {
+		s.polling.CheckOriginHandler = f
+	}
+}
+
+// SetCodec sets the codec used to encode and decode messages of the channels connected after the call,
+// the JSON codec is used by default. The raw args, e.g. the ack responses of EmitAndWait and Auth,
+// are in the args format of the codec, see protocol.ArgsCodec
+func (s *Server) SetCodec(codec protocol.Codec) { s.codec = codec }
+
+// SetOutBufferSize sets the size of the outgoing messages queue of the channels connected after the call.
+// The channel is closed if its queue overflows, sending to the full queue waits up to the transport SendTimeout.
+// Zero or negative size means the default one, the sizes below 16 are raised to it
+func (s *Server) SetOutBufferSize(size int) {
+	switch {
+	case size <= 0:
+		size = 0
+	case size < minOutBufferSize:
+		size = minOutBufferSize
+	}
+	s.outBufferSize = size
+}
+
+// SetMaxOutboxSize sets the maximum amount of the messages queued to be sent to the channels connected after
+// the call. The channel exceeding it is closed as a slow consumer instead of blocking the senders, e.g.
+// the broadcasts. Zero means no limit, it's the default
+func (s *Server) SetMaxOutboxSize(n int) { s.maxOutboxSize = n }
+
+// SetIdleTimeout sets the duration after which the channel is disconnected if it neither sends nor receives
+// any event, heartbeat messages are not taken into account. It's applied to the channels connected after
+// the call, zero means no idle timeout
+func (s *Server) SetIdleTimeout(d time.Duration) { s.idleTimeout = d }
+
+// SetHeartbeat sets the ping interval and timeout of the channels connected after the call, they are sent
+// to the clients in the handshake. Zero values mean the ones of the transport are used
+func (s *Server) SetHeartbeat(interval, timeout time.Duration) {
+	s.handshakeMu.Lock()
+	s.pingInterval, s.pingTimeout = interval, timeout
+	s.handshakeMu.Unlock()
+}
+
+// SetUpgrades enables advertising the websocket upgrade to the polling clients in the handshake, it's enabled
+// by default. Disabling it for the polling-only deployments, e.g. behind the proxies blocking websockets,
+// saves the clients a doomed upgrade attempt. It's applied to the channels connected after the call
+func (s *Server) SetUpgrades(enabled bool) {
+	s.handshakeMu.Lock()
+	s.noUpgrades = !enabled
+	s.handshakeMu.Unlock()
+}
+
+// SetOpenSequenceHook sets the function f called with the connection header before it's sent to the newly
+// connected channel c, e.g. to add Extra fields or to adjust the ping params according to the request.
+// The changed ping params are applied to the channel, the sid can't be changed
+func (s *Server) SetOpenSequenceHook(f func(h *ConnectionHeader, c *Channel)) {
+	s.handshakeMu.Lock()
+	s.openSequenceHook = f
+	s.handshakeMu.Unlock()
+}
+
+// upgrades returns the transports the clients are advertised to upgrade to
+func (s *Server) upgrades() []string {
+	s.handshakeMu.RLock()
+	defer s.handshakeMu.RUnlock()
+	if s.noUpgrades || s.websocket == nil {
+		return []string{}
+	}
+	return []string{"websocket"}
+}
+
+// heartbeatOf returns the ping interval and timeout for the connection conn
+func (s *Server) heartbeatOf(conn transport.Connection) (interval, timeout time.Duration) {
+	interval, timeout = conn.PingParams()
+
+	s.handshakeMu.RLock()
+	defer s.handshakeMu.RUnlock()
+	if s.pingInterval > 0 {
+		interval = s.pingInterval
+	}
+	if s.pingTimeout > 0 {
+		timeout = s.pingTimeout
+	}
+	return interval, timeout
+}
+
+// sendTimeoutOf returns the send timeout of the transport of connection conn
+func (s *Server) sendTimeoutOf(conn transport.Connection) time.Duration {
+	switch conn.(type) {
+	case *transport.PollingConnection:
+		return s.polling.SendTimeout
+	case *transport.WebsocketConnection:
+		return s.websocket.SendTimeout
+	}
+	return 0
+}
+
+// SetJSON sets the JSON implementation j used to marshal event payloads and unmarshal event args,
+// e.g. json-iterator or sonic. It's applied to the channels connected after the call, nil means encoding/json
+func (s *Server) SetJSON(j JSON) {
+	s.event.json = j
+	s.event.checkUseNumber()
+}
+
+// SetUseNumber enables decoding of numbers in event args as json.Number instead of float64, if they are decoded
+// into interface{} values, so large integers don't lose precision. The JSON implementation set with SetJSON
+// should implement NumberJSON to support it, otherwise a warning is logged and its own decoding is used
+func (s *Server) SetUseNumber(enabled bool) {
+	s.event.useNumber = enabled
+	s.event.checkUseNumber()
+}
+
+// SetAckOnUnknownEvent enables responding to an ack request for an event without a handler with an error object,
+// so the client doesn't wait for the response until its timeout. Otherwise the ack request is left without response
+func (s *Server) SetAckOnUnknownEvent(enabled bool) { s.event.ackOnUnknown = enabled }
+
+// SetAckTimeout sets the ack response timeout of EmitWithAck for the channels connected after the call,
+// the transport ping timeout is used by default. The ack is unregistered on timeout
+func (s *Server) SetAckTimeout(d time.Duration) { s.ackTimeout = d }
+
+// SetAckOnPanic enables responding to an ack request with an error object, if the handler panics.
+// Otherwise the ack request is left without response
+func (s *Server) SetAckOnPanic(enabled bool) { s.event.ackOnPanic = enabled }
+
 // GetChannel by it's sid
 func (s *Server) GetChannel(sid string) (*Channel, error) {
 	s.sidsMu.RLock()
@@ -81,61 +301,240 @@ func (s *Server) GetChannel(sid string) (*Channel, error) {
 	return c, nil
 }
 
-// Get amount of channels, joined to given room, using server
-func (s *Server) Amount(room string) int {
-	s.channelsMu.RLock()
-	defer s.channelsMu.RUnlock()
-	roomChannels, _ := s.channels[room]
-	return len(roomChannels)
+// Get amount of channels, joined to given room of the default namespace, using server
+func (s *Server) Amount(room string) int { return s.AmountIn(DefaultNamespace, room) }
+
+// AmountIn returns an amount of channels joined to the given room of the namespace
+func (s *Server) AmountIn(namespace, room string) int { return s.adapter.Amount(namespace, room) }
+
+// List returns a list of channels joined to the given room of the default namespace, using server
+func (s *Server) List(room string) []*Channel { return s.ListIn(DefaultNamespace, room) }
+
+// ListIn returns a list of channels joined to the given room of the namespace
+func (s *Server) ListIn(namespace, room string) []*Channel { return s.adapter.List(namespace, room) }
+
+// ListRooms returns names of the rooms of the default namespace with at least one joined channel, sorted
+func (s *Server) ListRooms() []string { return s.ListRoomsIn(DefaultNamespace) }
+
+// ListRoomsIn returns names of the rooms of the namespace with at least one joined channel, sorted
+func (s *Server) ListRoomsIn(namespace string) []string {
+	rooms := s.adapter.RoomNames(namespace)
+	sort.Strings(rooms)
+	return rooms
 }
 
-// List returns a list of channels joined to the given room, using server
-func (s *Server) List(room string) []*Channel {
-	s.channelsMu.RLock()
-	defer s.channelsMu.RUnlock()
+// RoomMembers returns sids of the channels joined to the given room of the default namespace, sorted
+func (s *Server) RoomMembers(room string) []string { return s.RoomMembersIn(DefaultNamespace, room) }
 
-	roomChannels, ok := s.channels[room]
-	if !ok {
-		return []*Channel{}
+// RoomMembersIn returns sids of the channels joined to the given room of the namespace, sorted
+func (s *Server) RoomMembersIn(namespace, room string) []string {
+	roomChannels := s.adapter.List(namespace, room)
+	sids := make([]string, 0, len(roomChannels))
+	for _, c := range roomChannels {
+		sids = append(sids, c.Id())
 	}
+	sort.Strings(sids)
+	return sids
+}
 
-	i := 0
-	roomChannelsCopy := make([]*Channel, len(roomChannels))
-	for channel := range roomChannels {
-		roomChannelsCopy[i] = channel
-		i++
+// RoomsOf returns names of the rooms the channel with given sid is joined to
+func (s *Server) RoomsOf(sid string) ([]string, error) {
+	c, err := s.GetChannel(sid)
+	if err != nil {
+		return nil, err
 	}
-
-	return roomChannelsCopy
+	return c.Rooms(), nil
 }
 
-// BroadcastTo the the given room an handler with payload, using server
+// BroadcastTo the the given room of the default namespace an handler with payload, using server
 func (s *Server) BroadcastTo(room, name string, payload interface{}) {
-	s.channelsMu.RLock()
-	defer s.channelsMu.RUnlock()
+	s.BroadcastToIn(DefaultNamespace, room, name, payload)
+}
 
-	roomChannels, ok := s.channels[room]
-	if !ok {
-		return
+// BroadcastToIn broadcasts to the given room of the namespace an event with payload
+func (s *Server) BroadcastToIn(namespace, room, name string, payload interface{}) {
+	s.adapter.Broadcast(&Broadcast{Namespace: namespace, Rooms: []string{room}, Event: name, Payload: payload})
+}
+
+// BroadcastToExcept broadcasts to the given room of the exclude channel namespace an event with payload,
+// skipping the exclude channel. The default namespace is used if exclude is nil
+func (s *Server) BroadcastToExcept(exclude *Channel, room, name string, payload interface{}) {
+	b := &Broadcast{Namespace: DefaultNamespace, Rooms: []string{room}, Event: name, Payload: payload}
+	if exclude != nil {
+		b.Namespace, b.Except = exclude.Namespace(), exclude.Id()
 	}
+	s.adapter.Broadcast(b)
+}
 
-	for cn := range roomChannels {
-		if cn.IsAlive() {
-			go cn.Emit(name, payload)
+// BroadcastToWithErrors broadcasts to the given room of the default namespace an event with payload and waits
+// until it's queued for every channel. Errors of the failed channels are returned keyed by channel id,
+// so dead channels could be disconnected. The event is delivered only to the channels listed by the adapter,
+// it isn't published through the adapter, as the errors can be collected only from the channels of the node
+func (s *Server) BroadcastToWithErrors(room, name string, payload interface{}) map[string]error {
+	channels := recipients(s.adapter, &Broadcast{Namespace: DefaultNamespace, Rooms: []string{room}})
+	s.metrics.OnBroadcast(room, name, len(channels))
+	return s.emitEach(channels, name, payload)
+}
+
+// BroadcastToRooms broadcasts to the given rooms of the default namespace an event with payload.
+// The event is emitted once per channel, even if it is joined to several of the rooms
+func (s *Server) BroadcastToRooms(rooms []string, name string, payload interface{}) {
+	s.adapter.Broadcast(&Broadcast{Namespace: DefaultNamespace, Rooms: rooms, Event: name, Payload: payload})
+}
+
+// emitEach emits an event with payload to every channel concurrently, bounded by the broadcast
+// concurrency. Errors are returned keyed by channel id
+func (s *Server) emitEach(channels []*Channel, name string, payload interface{}) map[string]error {
+	var (
+		wg       sync.WaitGroup
+		errs     = make(map[string]error)
+		errsMu   sync.Mutex
+		limiterC = make(chan struct{}, s.broadcastConcurrency)
+	)
+
+	for _, cn := range channels {
+		limiterC <- struct{}{}
+		wg.Add(1)
+		go func(cn *Channel) {
+			defer func() {
+				<-limiterC
+				wg.Done()
+			}()
+
+			if err := cn.Emit(name, payload); err != nil {
+				s.logger.Info("Server.emitEach() failed to emit:", "sid", cn.Id(), "event", name, "err", err)
+				errsMu.Lock()
+				errs[cn.Id()] = err
+				errsMu.Unlock()
+			}
+		}(cn)
+	}
+
+	wg.Wait()
+	return errs
+}
+
+// SetBroadcastConcurrency sets the maximum amount of channels emitted to concurrently by a single broadcast
+func (s *Server) SetBroadcastConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.broadcastConcurrency = n
+}
+
+// BroadcastToAck emits to the given room an event with given name and payload requesting an ack from every
+// channel, and collects the responses keyed by channel id. Channels which didn't respond within the timeout
+// are omitted from the result. The event is emitted only to the channels listed by the adapter, it isn't published
+// through the adapter, as the responses can be collected only from the channels of the node
+func (s *Server) BroadcastToAck(room, name string, payload interface{}, timeout time.Duration) map[string]interface{} {
+	var (
+		wg        sync.WaitGroup
+		results   = make(map[string]interface{})
+		resultsMu sync.Mutex
+	)
+
+	recipients := 0
+	for _, cn := range s.List(room) {
+		if !cn.IsAlive() {
+			continue
 		}
+
+		recipients++
+		wg.Add(1)
+		go func(cn *Channel) {
+			defer wg.Done()
+
+			data, err := cn.EmitAndWait(name, payload, timeout)
+			if err != nil {
+				return
+			}
+
+			var result interface{}
+			decoded, err := cn.unmarshalArgs(data, &result)
+			if !decoded {
+				err = cn.json.Unmarshal([]byte(data), &result)
+			}
+			if err != nil {
+				result = data
+			}
+
+			resultsMu.Lock()
+			results[cn.Id()] = result
+			resultsMu.Unlock()
+		}(cn)
 	}
+
+	s.metrics.OnBroadcast(room, name, recipients)
+	wg.Wait()
+	return results
 }
 
 // Broadcast to all clients
 func (s *Server) BroadcastToAll(method string, payload interface{}) {
+	s.BroadcastToAllExcept(nil, method, payload)
+}
+
+// BroadcastToAllExcept broadcasts to all clients except the exclude channel
+func (s *Server) BroadcastToAllExcept(exclude *Channel, method string, payload interface{}) {
+	b := &Broadcast{All: true, Event: method, Payload: payload}
+	if exclude != nil {
+		b.Except = exclude.Id()
+	}
+	s.adapter.Broadcast(b)
+}
+
+// aliveChannelsExcept returns the alive channels of the server except the one with the given id, empty means none
+func (s *Server) aliveChannelsExcept(except string) []*Channel {
 	s.sidsMu.RLock()
 	defer s.sidsMu.RUnlock()
 
-	for _, cn := range s.sids {
-		if cn.IsAlive() {
-			go cn.Emit(method, payload)
+	channels := make([]*Channel, 0, len(s.sids))
+	for _, c := range s.sids {
+		if c.IsAlive() && (except == "" || c.Id() != except) {
+			channels = append(channels, c)
 		}
 	}
+	return channels
+}
+
+// Shutdown gracefully stops the server. New connections are refused, every live channel is closed
+// like Channel.Close, so it's sent a socket.io DISCONNECT packet after its pending messages. Then Shutdown
+// waits until all channels are closed or the context expires, in which case the context error is returned
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Set()
+
+	s.sidsMu.RLock()
+	channels := make([]*Channel, 0, len(s.sids))
+	for _, c := range s.sids {
+		channels = append(channels, c)
+	}
+	s.sidsMu.RUnlock()
+
+	// the channel whose disconnect packet can't be queued, e.g. the slow one, is closed immediately
+	for _, c := range channels {
+		if c.IsAlive() {
+			c.disconnect(DisconnectReasonShutdown)
+		}
+	}
+
+	for _, c := range channels {
+		select {
+		case <-c.closedC:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Disconnect the channel with the given sid gracefully, see Channel.Close
+func (s *Server) Disconnect(sid string) error {
+	c, err := s.GetChannel(sid)
+	if err != nil {
+		return err
+	}
+	return c.Close()
 }
 
 // onConnection fires on connection and on connection upgrade
@@ -147,148 +546,333 @@ func onConnection(c *Channel) {
 
 // onDisconnection fires on disconnection
 func onDisconnection(c *Channel) {
-	c.server.channelsMu.Lock()
-	defer c.server.channelsMu.Unlock()
-
 	defer func() {
 		c.server.sidsMu.Lock()
-		delete(c.server.sids, c.Id())
+		// the channel could be replaced by the upgraded one with the same id
+		if c.server.sids[c.Id()] == c {
+			delete(c.server.sids, c.Id())
+			c.server.connectionLimits.release(c.remoteIP)
+			c.server.metrics.OnDisconnect(c)
+		} else if c.takeConnectPending() {
+			// the client disconnected without sending the CONNECT packet
+			delete(c.server.pendingSids, c.Id())
+			c.server.connectionLimits.release(c.remoteIP)
+		}
 		c.server.sidsMu.Unlock()
 	}()
 
-	_, ok := c.server.rooms[c]
-	if !ok {
-		return
+	c.server.adapter.LeaveAll(c)
+	c.server.removeUser(c)
+}
+
+// sendOpenSequence to the given channel c, nothing is sent if the sequence can't be encoded
+func (s *Server) sendOpenSequence(c *Channel) error {
+	jsonHdr, err := c.json.Marshal(&c.connHeader)
+	if err != nil {
+		return err
 	}
 
-	for room := range c.server.rooms[c] {
-		if curRoom, ok := c.server.channels[room]; ok {
-			delete(curRoom, c)
-			if len(curRoom) == 0 {
-				delete(c.server.channels, room)
-			}
+	messages := []*protocol.Message{{Type: protocol.MessageTypeOpen, Args: string(jsonHdr)}}
+	// the connection is acknowledged on the CONNECT packet of the socket.io v3/v4 client
+	if !c.isConnectPending() {
+		messages = append(messages, &protocol.Message{Type: protocol.MessageTypeEmpty})
+	}
+
+	commands := make([]string, 0, len(messages))
+	for _, m := range messages {
+		// the open packet is the engine.io one, so it's always text
+		encode := c.encodePacket
+		if m.Type == protocol.MessageTypeOpen {
+			encode = c.codec.Encode
 		}
+		command, err := encode(m)
+		if err != nil {
+			return err
+		}
+		commands = append(commands, command)
+	}
+
+	for _, command := range commands {
+		c.outC <- command
 	}
-	delete(c.server.rooms, c)
+	return nil
 }
 
-// sendOpenSequence to the given channel c
-func (s *Server) sendOpenSequence(c *Channel) {
-	jsonHdr, err := json.Marshal(&c.connHeader)
+// setupEventLoop for the given connection conn on the given address with HTTP header and URL query.
+// An error is returned if the open sequence can't be sent, the connection is not set up then
+func (s *Server) setupEventLoop(conn transport.Connection, address string, header http.Header, query url.Values) error {
+	sid, err := s.newSid()
 	if err != nil {
-		panic(err)
-	}
-	c.outC <- protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeOpen, Args: string(jsonHdr)})
-	c.outC <- protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeEmpty})
-}
-
-// setupEventLoop for the given connection conn on the given address with HTTP header
-func (s *Server) setupEventLoop(conn transport.Connection, address string, header http.Header) {
-	interval, timeout := conn.PingParams()
-	connHeader := connectionHeader{
-		Sid: func(s string) string {
-			hash := fmt.Sprintf("%s %s %b %b", s, time.Now(), rand.Uint32(), rand.Uint32())
-			buf, sum := bytes.NewBuffer(nil), md5.Sum([]byte(hash))
-			encoder := base64.NewEncoder(base64.URLEncoding, buf)
-			encoder.Write(sum[:])
-			encoder.Close()
-			return buf.String()[:20]
-		}(address),
-		Upgrades:     []string{"websocket"},
+		s.logger.Warn("Server.setupEventLoop() can't generate sid:", "err", err)
+		return err
+	}
+	// the channel holds the sid after the connection handler is called, the one awaiting
+	// the CONNECT packet releases it by itself
+	explicitConnect, set := connectsExplicitly(query), false
+	defer func() {
+		if !explicitConnect || !set {
+			s.releaseSid(sid)
+		}
+	}()
+
+	interval, timeout := s.heartbeatOf(conn)
+	connHeader := ConnectionHeader{
+		Sid:          sid,
+		Upgrades:     s.upgrades(),
 		PingInterval: int(interval / time.Millisecond),
 		PingTimeout:  int(timeout / time.Millisecond),
 	}
 
-	c := &Channel{conn: conn, address: address, header: header, server: s, connHeader: connHeader}
+	c := &Channel{conn: conn, address: address, header: header, query: query, remoteIP: s.remoteIP(address, header), server: s, connHeader: connHeader, codec: s.codec, json: s.event.json, logger: s.logger,
+		outBufferSize: s.outBufferSize, maxOutboxSize: s.maxOutboxSize, eventsQueueSize: s.eventsQueueSize, sendTimeout: s.sendTimeoutOf(conn), idleTimeout: s.idleTimeout, ackTimeout: s.ackTimeout,
+		pingInterval: interval, pingTimeout: timeout}
 	c.init()
+	if explicitConnect {
+		c.connectPending = 1
+	}
+
+	s.handshakeMu.RLock()
+	hook := s.openSequenceHook
+	s.handshakeMu.RUnlock()
+	if hook != nil {
+		hook(&c.connHeader, c)
+		c.connHeader.Sid = sid // it's reserved for the channel
+		c.pingInterval = time.Duration(c.connHeader.PingInterval) * time.Millisecond
+		c.pingTimeout = time.Duration(c.connHeader.PingTimeout) * time.Millisecond
+		_, timeout = c.pingParams()
+	}
+
+	if err := s.sendOpenSequence(c); err != nil {
+		s.logger.Warn("Server.setupEventLoop() can't send the open sequence:", "err", err)
+		return err
+	}
+	set = true
 
 	switch conn.(type) {
 	case *transport.PollingConnection:
 		conn.(*transport.PollingConnection).Transport.SetSid(connHeader.Sid, conn)
 	}
 
-	s.sendOpenSequence(c)
-
 	go c.inLoop(s.event)
 	go c.outLoop(s.event)
+	go c.heartbeatLoop(s.event)
+	if c.idleTimeout > 0 {
+		go c.idleLoop()
+	}
+
+	if explicitConnect {
+		// OnConnection handler fires on the CONNECT packet, see acceptConnect
+		time.AfterFunc(timeout, func() {
+			if c.isConnectPending() {
+				c.closeWithReason(s.event, DisconnectReasonConnectTimeout)
+			}
+		})
+		return nil
+	}
 
+	c.setState(StateConnected)
 	s.callHandler(c, OnConnection)
+	s.metrics.OnConnect(c)
+	return nil
 }
 
 // upgradeEventLoop at transport upgrade
-func (s *Server) upgradeEventLoop(conn transport.Connection, remoteAddr string, header http.Header, sid string) {
+func (s *Server) upgradeEventLoop(conn transport.Connection, remoteAddr string, header http.Header, query url.Values, sid string) {
 	s.logger.Debug("Server.upgradeEventLoop() fired")
 
 	pollingChannel, err := s.GetChannel(sid)
 	if err != nil {
-		s.logger.Warn("Server.upgradeEventLoop() can't find channel for session:", zap.String("sid", sid))
+		s.logger.Warn("Server.upgradeEventLoop() can't find channel for session:", "sid", sid)
 		return
 	}
 
 	s.logger.Debug("Server.upgradeEventLoop() obtained a polling channel")
-	interval, timeout := conn.PingParams()
-	connHeader := connectionHeader{
+	pollingChannel.setState(StateUpgrading)
+	// the client keeps the heartbeat negotiated by the polling handshake, e.g. adjusted by the open sequence hook
+	interval, timeout := pollingChannel.pingParams()
+	connHeader := ConnectionHeader{
 		Sid:          sid,
 		Upgrades:     []string{},
 		PingInterval: int(interval / time.Millisecond),
 		PingTimeout:  int(timeout / time.Millisecond),
 	}
 
-	c := &Channel{conn: conn, address: remoteAddr, header: header, server: s, connHeader: connHeader}
+	c := &Channel{conn: conn, address: remoteAddr, header: header, query: query, remoteIP: pollingChannel.remoteIP, server: s, connHeader: connHeader, codec: s.codec, json: s.event.json, logger: s.logger,
+		outBufferSize: s.outBufferSize, maxOutboxSize: s.maxOutboxSize, eventsQueueSize: s.eventsQueueSize, sendTimeout: s.sendTimeoutOf(conn), idleTimeout: s.idleTimeout, ackTimeout: s.ackTimeout,
+		pingInterval: interval, pingTimeout: timeout, namespace: pollingChannel.namespace, auth: pollingChannel.Auth()}
 	c.init()
+	c.setState(StateUpgrading)
+	// acks requested via the polling channel are responded via the upgraded one
+	c.ack = pollingChannel.ack
 	s.logger.Debug("Server.upgradeEventLoop() initialized a new channel")
 
 	go c.inLoop(s.event)
 	go c.outLoop(s.event)
+	go c.heartbeatLoop(s.event)
+	if c.idleTimeout > 0 {
+		go c.idleLoop()
+	}
 
-	s.logger.Debug("Server.upgradeEventLoop() fired c.inLoop() and c.outLoop() in separate go-routines")
-	onConnection(c)
+	s.logger.Debug("Server.upgradeEventLoop() fired c.inLoop(), c.outLoop() and c.heartbeatLoop() in separate go-routines")
 
 	// synchronize stubbing polling channel with receiving "2probe" message
-	<-c.upgradedC
+	select {
+	case <-c.upgradedC:
+	case <-c.closedC:
+		s.logger.Debug("Server.upgradeEventLoop() upgrade failed, the polling channel is kept")
+		pollingChannel.setState(StateConnected)
+		return
+	}
+
+	s.completeUpgrade(pollingChannel, c)
+	c.setState(StateConnected)
 	pollingChannel.stub()
+
+	if onUpgrade := s.upgradeHook(); onUpgrade != nil {
+		onUpgrade(c)
+	}
+}
+
+// completeUpgrade replaces the polling channel by the upgraded one atomically for the broadcasts. The messages
+// queued to the polling channel and the ones sent to it later are delivered by the upgraded channel,
+// the upgraded channel takes the polling channel rooms, user and rate limiting state
+func (s *Server) completeUpgrade(polling, upgraded *Channel) {
+	// the polling outgoing loop is stopped first, so the pending messages aren't taken by it while moving
+	// them, the ones queued by the polling connection go first
+	upgraded.enqueuePending(polling.stopOutLoop())
+
+	polling.upgradedMu.Lock()
+	polling.upgraded = upgraded
+	polling.upgradedMu.Unlock()
+	upgraded.enqueuePending(polling.drainQueued())
+
+	s.sidsMu.Lock()
+	defer s.sidsMu.Unlock()
+
+	s.adapter.Replace(polling, upgraded)
+
+	s.usersMu.Lock()
+	if user := polling.user; user != "" {
+		s.setUser(polling, "")
+		s.setUser(upgraded, user)
+	}
+	s.usersMu.Unlock()
+
+	upgraded.inheritBuckets(polling)
+
+	s.sids[upgraded.Id()] = upgraded
+}
+
+// acquireConnection counts a new connection from remoteIP, responding with an error if a connections limit is reached
+func (s *Server) acquireConnection(w http.ResponseWriter, remoteIP string) bool {
+	switch err := s.connectionLimits.acquire(remoteIP); err {
+	case nil:
+		return true
+	case ErrorMaxConnections:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	}
+	s.logger.Info("Server.acquireConnection() rejected connection:", "remoteIP", remoteIP)
+	return false
 }
 
 // ServeHTTP makes Server to implement http.Handler
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.matchesPath(r) {
+		http.NotFound(w, r)
+		return
+	}
+
 	session, transportName := r.URL.Query().Get("sid"), r.URL.Query().Get("transport")
 
+	// CORS preflight requests are made only by polling clients
+	if r.Method == http.MethodOptions {
+		if s.polling == nil {
+			http.Error(w, ErrorTransportDisabled.Error(), http.StatusBadRequest)
+			return
+		}
+		s.polling.Serve(w, r)
+		return
+	}
+
+	// only already established polling sessions are served during shutdown
+	if s.shuttingDown.Get() && (session == "" || transportName != "polling") {
+		http.Error(w, ErrorServerShutdown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
 	switch transportName {
 	case "polling":
+		if s.polling == nil {
+			http.Error(w, ErrorTransportDisabled.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// session is empty in first polling request, or first and single websocket request
 		if session != "" {
 			s.polling.Serve(w, r)
 			return
 		}
 
+		remoteIP := s.remoteIP(r.RemoteAddr, r.Header)
+		if !s.acquireConnection(w, remoteIP) {
+			return
+		}
+
 		conn, err := s.polling.HandleConnection(w, r)
 		if err != nil {
+			s.connectionLimits.release(remoteIP)
 			return
 		}
 
-		s.setupEventLoop(conn, r.RemoteAddr, r.Header)
+		if err := s.setupEventLoop(conn, r.RemoteAddr, r.Header, r.URL.Query()); err != nil {
+			s.connectionLimits.release(remoteIP)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		s.logger.Debug("Server.ServeHTTP() created a PollingConnection")
 		conn.(*transport.PollingConnection).PollingWriter(w, r)
 
 	case "websocket":
+		if s.websocket == nil {
+			http.Error(w, ErrorTransportDisabled.Error(), http.StatusBadRequest)
+			return
+		}
+
 		if session != "" {
+			// the upgrade of the polling session of another node is forwarded to it
+			if _, err := s.GetChannel(session); err != nil && s.polling != nil && s.polling.Forward(w, r) {
+				return
+			}
+
 			s.logger.Debug("Server.ServeHTTP() is firing s.websocket.HandleConnection() for upgrade")
 			conn, err := s.websocket.HandleConnection(w, r)
 			if err != nil {
-				s.logger.Warn("Server.ServeHTTP() upgrade error:", zap.Error(err))
+				s.logger.Warn("Server.ServeHTTP() upgrade error:", "err", err)
 				return
 			}
-			s.upgradeEventLoop(conn, r.RemoteAddr, r.Header, session)
+			s.upgradeEventLoop(conn, r.RemoteAddr, r.Header, r.URL.Query(), session)
 			s.logger.Debug("Server.ServeHTTP() upgraded to a WebsocketConnection")
 			return
 		}
 
+		remoteIP := s.remoteIP(r.RemoteAddr, r.Header)
+		if !s.acquireConnection(w, remoteIP) {
+			return
+		}
+
 		conn, err := s.websocket.HandleConnection(w, r)
 		if err != nil {
+			s.connectionLimits.release(remoteIP)
 			return
 		}
 
-		s.setupEventLoop(conn, r.RemoteAddr, r.Header)
+		if err := s.setupEventLoop(conn, r.RemoteAddr, r.Header, r.URL.Query()); err != nil {
+			s.connectionLimits.release(remoteIP)
+			conn.Close()
+			return
+		}
 		s.logger.Debug("Server.ServeHTTP() created a WebsocketConnection")
 	}
 }
@@ -300,9 +884,16 @@ func (s *Server) CountChannels() int {
 	return len(s.sids)
 }
 
-// CountRooms returns an amount of rooms with at least one joined channel
-func (s *Server) CountRooms() int {
-	s.channelsMu.RLock()
-	defer s.channelsMu.RUnlock()
-	return len(s.channels)
+// PendingAcks returns the amount of the acks requested by the connected channels and waiting for the response
+func (s *Server) PendingAcks() int {
+	s.sidsMu.RLock()
+	defer s.sidsMu.RUnlock()
+	pending := 0
+	for _, c := range s.sids {
+		pending += c.PendingAcks()
+	}
+	return pending
 }
+
+// CountRooms returns an amount of rooms with at least one joined channel
+func (s *Server) CountRooms() int { return s.adapter.CountRooms() }
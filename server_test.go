@@ -0,0 +1,44 @@
+package socketio
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vanti-dev/golang-socketio/logging"
+)
+
+func TestNewServerKeepsGlobalLogger(t *testing.T) {
+	global := &warnLogger{Logger: logging.Nop()}
+	logging.SetLog(global)
+	defer logging.SetLog(nil)
+
+	s := NewServer(nil, nil, &warnLogger{Logger: logging.Nop()})
+	if logging.Log() != global {
+		t.Fatal("NewServer() replaced the global logger")
+	}
+	if s.logger == global {
+		t.Fatal("the server uses the global logger instead of its own")
+	}
+}
+
+func TestShutdownSlowChannel(t *testing.T) {
+	s := NewServer(nil, nil, nil)
+	c := newTestChannel(s, "sid")
+	// the outgoing loop isn't started, so the queue stays full
+	for len(c.outC) < cap(c.outC) {
+		c.outC <- "42[\"event\"]"
+	}
+	s.sidsMu.Lock()
+	s.sids["sid"] = c
+	s.sidsMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v, want nil", err)
+	}
+	if reason := c.DisconnectReason(); reason != DisconnectReasonShutdown {
+		t.Fatalf("DisconnectReason() = %q, want %q", reason, DisconnectReasonShutdown)
+	}
+}
@@ -0,0 +1,52 @@
+package socketio
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// defaultSidLength is the default length of the generated session ids
+const defaultSidLength = 20
+
+// SetSidLength sets the length of the session ids generated for the channels connected after the call,
+// the default length is 20. Session ids are URL safe base64 strings of random bytes
+func (s *Server) SetSidLength(length int) {
+	s.sidsMu.Lock()
+	s.sidLength = length
+	s.sidsMu.Unlock()
+}
+
+// newSid generates a session id unique among the connected channels and the ones being connected.
+// The id is reserved until releaseSid is called, the connected channel keeps it
+func (s *Server) newSid() (string, error) {
+	s.sidsMu.Lock()
+	defer s.sidsMu.Unlock()
+
+	length := s.sidLength
+	if length <= 0 {
+		length = defaultSidLength
+	}
+
+	buf := make([]byte, base64.RawURLEncoding.DecodedLen(length)+1)
+	for {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		sid := base64.RawURLEncoding.EncodeToString(buf)[:length]
+
+		// regenerate on collision, so one client's requests are never routed into another's session
+		_, connected := s.sids[sid]
+		_, pending := s.pendingSids[sid]
+		if !connected && !pending {
+			s.pendingSids[sid] = struct{}{}
+			return sid, nil
+		}
+	}
+}
+
+// releaseSid removes the reservation of sid made by newSid
+func (s *Server) releaseSid(sid string) {
+	s.sidsMu.Lock()
+	delete(s.pendingSids, sid)
+	s.sidsMu.Unlock()
+}
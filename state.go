@@ -0,0 +1,42 @@
+package socketio
+
+// ConnectionState represents a phase of the channel connection lifecycle
+type ConnectionState int
+
+const (
+	StateConnecting ConnectionState = iota // the connection is being set up
+	StateConnected                         // the connection is established
+	StateUpgrading                         // the transport upgrade is in progress
+	StateClosed                            // the connection is closed
+)
+
+// String returns a name of the state
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateUpgrading:
+		return "upgrading"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// State returns the current state of the channel connection
+func (c *Channel) State() ConnectionState {
+	c.aliveMu.Lock()
+	defer c.aliveMu.Unlock()
+	return c.state
+}
+
+// setState sets the state of the channel connection, the closed channel state can't be changed
+func (c *Channel) setState(state ConnectionState) {
+	c.aliveMu.Lock()
+	if c.state != StateClosed {
+		c.state = state
+	}
+	c.aliveMu.Unlock()
+}
@@ -0,0 +1,109 @@
+package socketio
+
+import (
+	"crypto/tls"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vanti-dev/golang-socketio/logging"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes
+const certCheckInterval = 10 * time.Second
+
+// ListenAndServeTLS listens on the TCP network address addr and serves the server s over TLS with the certificate
+// and the key files. The files are reloaded when they change, so the certificate is rotated without dropping
+// the established connections
+func ListenAndServeTLS(addr, certFile, keyFile string, s *Server) error {
+	loader, err := newCertLoader(certFile, keyFile, s.logger)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   s,
+		TLSConfig: &tls.Config{GetCertificate: loader.getCertificate},
+	}
+	return srv.ListenAndServeTLS("", "")
+}
+
+// certLoader loads the certificate from the files, reloading it when they change
+type certLoader struct {
+	certFile, keyFile string
+
+	cert      *tls.Certificate
+	modTime   time.Time // the latest modification time of the files the certificate is loaded from
+	checkedAt time.Time
+	mu        sync.Mutex
+
+	logger logging.Logger
+}
+
+// newCertLoader returns the loader of the certificate from the given files, the certificate is loaded immediately
+func newCertLoader(certFile, keyFile string, logger logging.Logger) (*certLoader, error) {
+	l := &certLoader{certFile: certFile, keyFile: keyFile, logger: logger}
+	modTime, err := l.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := l.load(modTime); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// filesModTime returns the latest modification time of the certificate and the key files
+func (l *certLoader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{l.certFile, l.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load loads the certificate from the files modified at modTime, mu should be locked if the loader is in use
+func (l *certLoader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return err
+	}
+	l.cert, l.modTime, l.checkedAt = &cert, modTime, time.Now()
+	return nil
+}
+
+// getCertificate returns the certificate for the TLS handshake, reloading it if the files changed.
+// The previous certificate is kept if the files can't be loaded, e.g. while they're being replaced
+func (l *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Since(l.checkedAt) < certCheckInterval {
+		return l.cert, nil
+	}
+	l.checkedAt = time.Now()
+
+	modTime, err := l.filesModTime()
+	if err != nil {
+		l.logger.Warn("certLoader.getCertificate() can't check the certificate files:", "err", err)
+		return l.cert, nil
+	}
+	if !modTime.After(l.modTime) {
+		return l.cert, nil
+	}
+
+	if err := l.load(modTime); err != nil {
+		l.logger.Warn("certLoader.getCertificate() can't reload the certificate:", "err", err)
+		return l.cert, nil
+	}
+	l.logger.Info("certLoader.getCertificate() reloaded the certificate", "certFile", l.certFile)
+	return l.cert, nil
+}
@@ -0,0 +1,79 @@
+package transport
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions represents cross-origin resource sharing settings of the polling transport
+type CORSOptions struct {
+	AllowedOrigins []string // origins allowed to make requests, "*" allows any origin unless AllowCredentials is set
+
+	// AllowCredentials allows requests with cookies and HTTP authentication. The "*" origin is ignored then,
+	// only the origins listed explicitly are allowed to make credentialed requests
+	AllowCredentials bool
+
+	AllowedHeaders []string // request headers allowed in addition to the simple ones
+	MaxAge         time.Duration
+}
+
+// allowedOrigin returns the value of Access-Control-Allow-Origin header for the given request origin,
+// or an empty string if the origin is not allowed
+func (o *CORSOptions) allowedOrigin(origin string) string {
+	for _, allowed := range o.AllowedOrigins {
+		switch {
+		case allowed == "*":
+			// browsers forbid credentials with a wildcard, and echoing any origin back would allow them
+			if !o.AllowCredentials {
+				return "*"
+			}
+		case strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin):
+			return origin
+		}
+	}
+	return ""
+}
+
+// setHeaders sets CORS headers into h for the request r, returns false if r is a cross-origin
+// request from the origin which is not allowed
+func (o *CORSOptions) setHeaders(h http.Header, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	allowedOrigin := o.allowedOrigin(origin)
+	if allowedOrigin == "" {
+		return false
+	}
+
+	h.Set("Access-Control-Allow-Origin", allowedOrigin)
+	if allowedOrigin != "*" {
+		h.Add("Vary", "Origin")
+	}
+	if o.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// preflight responds to the preflight OPTIONS request r
+func (o *CORSOptions) preflight(w http.ResponseWriter, r *http.Request) {
+	if !o.setHeaders(w.Header(), r) {
+		http.Error(w, errOriginNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	if len(o.AllowedHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(o.AllowedHeaders, ", "))
+	} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		w.Header().Set("Access-Control-Allow-Headers", requested)
+	}
+	if o.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(o.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
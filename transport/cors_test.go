@@ -0,0 +1,21 @@
+package transport
+
+import "testing"
+
+func TestAllowedOriginIgnoresWildcardWithCredentials(t *testing.T) {
+	tests := []struct {
+		options CORSOptions
+		origin  string
+		want    string
+	}{
+		{CORSOptions{AllowedOrigins: []string{"*"}}, "https://evil.example", "*"},
+		{CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true}, "https://evil.example", ""},
+		{CORSOptions{AllowedOrigins: []string{"*", "https://app.example/"}, AllowCredentials: true}, "https://app.example", "https://app.example"},
+		{CORSOptions{AllowedOrigins: []string{"https://app.example"}, AllowCredentials: true}, "https://evil.example", ""},
+	}
+	for _, tt := range tests {
+		if got := tt.options.allowedOrigin(tt.origin); got != tt.want {
+			t.Errorf("allowedOrigin(%s) with %+v = %q, want %q", tt.origin, tt.options, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+
+	"github.com/gorilla/websocket"
+)
+
+// Kinds of the errors returned by the connections and the transports, match them with errors.Is
+var (
+	ErrTimeout   = errors.New("transport timeout")         // nothing was read or written in time
+	ErrClosed    = errors.New("transport closed")          // the connection is closed by either side
+	ErrProtocol  = errors.New("transport protocol error")  // the other side sent something unexpected
+	ErrHandshake = errors.New("transport handshake error") // the connection couldn't be established
+)
+
+// Error is an error of the transport of the given kind. It matches its kind with errors.Is
+// and unwraps to the underlying error, if any
+type Error struct {
+	Kind error  // one of ErrTimeout, ErrClosed, ErrProtocol and ErrHandshake
+	Msg  string // description, the underlying error text is used if empty
+	Err  error  // underlying error, may be nil
+}
+
+// newError returns the transport error of the given kind with the description msg
+func newError(kind error, msg string) *Error { return &Error{Kind: kind, Msg: msg} }
+
+// Error implements error interface
+func (e *Error) Error() string {
+	if e.Msg == "" && e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Msg
+}
+
+// Is reports whether the target is the kind of the error
+func (e *Error) Is(target error) bool { return target == e.Kind }
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error { return e.Err }
+
+// wrapError returns err of the underlying network connection as the transport error of the matching kind,
+// the errors of unknown kind are returned as is
+func wrapError(err error) error {
+	var (
+		netErr   net.Error
+		closeErr *websocket.CloseError
+	)
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return &Error{Kind: ErrTimeout, Err: err}
+	case errors.As(err, &closeErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, net.ErrClosed), errors.Is(err, websocket.ErrCloseSent):
+		return &Error{Kind: ErrClosed, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"net/http"
+	"strings"
+)
+
+var errOriginNotAllowed = newError(ErrHandshake, "origin not allowed")
+
+// OriginAllowlist returns an origin checking function for the transports which allows requests only from
+// the given origins, e.g. "https://example.com". Origin "*" allows any origin. Requests without the Origin
+// header are allowed as they are not made by browsers
+func OriginAllowlist(origins ...string) func(r *http.Request) bool {
+	allowed := make(map[string]struct{}, len(origins))
+	for _, origin := range origins {
+		allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = struct{}{}
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if _, ok := allowed["*"]; ok {
+			return true
+		}
+		_, ok := allowed[strings.ToLower(origin)]
+		return ok
+	}
+}
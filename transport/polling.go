@@ -1,39 +1,160 @@
 package transport
 
 import (
-	"errors"
-	"go.uber.org/zap"
+	"compress/gzip"
+	"encoding/base64"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"fmt"
+	"github.com/vanti-dev/golang-socketio/logging"
 	"github.com/vanti-dev/golang-socketio/protocol"
 )
 
 const (
-	PlDefaultPingInterval   = 30 * time.Second
-	PlDefaultPingTimeout    = 60 * time.Second
-	PlDefaultReceiveTimeout = 60 * time.Second
-	PlDefaultSendTimeout    = 60 * time.Second
+	PlDefaultPingInterval    = 30 * time.Second
+	PlDefaultPingTimeout     = 60 * time.Second
+	PlDefaultReceiveTimeout  = 60 * time.Second
+	PlDefaultSendTimeout     = 60 * time.Second
+	PlDefaultWriteBufferSize = 100
 
 	StopMessage     = "stop"
 	UpgradedMessage = "upgrade"
-	noError         = "0"
 
-	hijackingNotSupported = "webserver doesn't support hijacking"
+	// recordSeparator delimits packets of the engine.io v4 polling payload
+	recordSeparator = "\x1e"
+	eioVersion4     = "4"
+
+	// base64Prefix marks a base64 encoded binary packet of the polling payload
+	base64Prefix = "b"
+	// binaryMessageType is the engine.io message type of the binary frames
+	binaryMessageType = 4
 )
 
 var (
-	errGetMessageTimeout       = errors.New("timeout waiting for the message")
-	errReceivedConnectionClose = errors.New("received connection close")
-	errWriteMessageTimeout     = errors.New("timeout waiting for write")
+	errGetMessageTimeout       = newError(ErrTimeout, "timeout waiting for the message")
+	errReceivedConnectionClose = newError(ErrClosed, "received connection close")
+	errWriteMessageTimeout     = newError(ErrTimeout, "timeout waiting for write")
+	errWrongPayload            = newError(ErrProtocol, "wrong payload")
+	errSessionClosed           = newError(ErrClosed, "polling session is closed")
 )
 
 // withLength returns s as a message with length
-func withLength(m string) string { return fmt.Sprintf("%d:%s", len(m), m) }
+func withLength(m string) string { return fmt.Sprintf("%d:%s", utf16Len(m), m) }
+
+// utf16Len returns the length of s in UTF-16 code units, the way the JavaScript clients count the characters
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16RuneLen(r)
+	}
+	return n
+}
+
+// utf16RuneLen returns the number of UTF-16 code units encoding r, the runes outside the BMP take a surrogate pair
+func utf16RuneLen(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// encodePayload frames packets into a single polling payload according to the engine.io version eio
+func encodePayload(packets []string, eio string) string {
+	if eio == eioVersion4 {
+		return strings.Join(packets, recordSeparator)
+	}
+
+	var payload strings.Builder
+	for _, packet := range packets {
+		payload.WriteString(withLength(packet))
+	}
+	return payload.String()
+}
+
+// decodePayload splits a polling payload into packets according to the engine.io version eio.
+// Engine.io v4 packets are separated with the record separator, earlier versions prefix every
+// packet with its length
+func decodePayload(payload string, eio string) ([]string, error) {
+	if eio == eioVersion4 {
+		// the empty payload has no packets, as for the earlier versions
+		if payload == "" {
+			return []string{}, nil
+		}
+		return strings.Split(payload, recordSeparator), nil
+	}
+
+	var packets []string
+	for len(payload) > 0 {
+		index := strings.IndexByte(payload, ':')
+		if index == -1 {
+			return nil, errWrongPayload
+		}
+
+		length, err := strconv.Atoi(payload[:index])
+		if err != nil || length < 0 {
+			return nil, errWrongPayload
+		}
+		payload = payload[index+1:]
+
+		// the length is specified in UTF-16 code units
+		end := 0
+		for units := 0; units < length; {
+			if end >= len(payload) {
+				return nil, errWrongPayload
+			}
+			r, size := utf8.DecodeRuneInString(payload[end:])
+			units += utf16RuneLen(r)
+			end += size
+			if units > length {
+				// the length splits a surrogate pair
+				return nil, errWrongPayload
+			}
+		}
+
+		packets = append(packets, payload[:end])
+		payload = payload[end:]
+	}
+	return packets, nil
+}
+
+// encodeBase64 returns the engine.io binary frame as a base64 encoded packet according to the engine.io version eio.
+// Engine.io v4 packet is the base64 prefix followed by the data, earlier versions put the message type between them
+func encodeBase64(frame []byte, eio string) string {
+	if eio == eioVersion4 {
+		return base64Prefix + base64.StdEncoding.EncodeToString(frame[1:])
+	}
+	return base64Prefix + strconv.Itoa(int(frame[0])) + base64.StdEncoding.EncodeToString(frame[1:])
+}
+
+// decodeBase64 returns the engine.io binary frame of the base64 encoded packet according to the engine.io version eio
+func decodeBase64(packet string, eio string) ([]byte, error) {
+	if !strings.HasPrefix(packet, base64Prefix) {
+		return nil, errPacketWrong
+	}
+	packet = packet[len(base64Prefix):]
+
+	// engine.io v4 binary packets are always messages
+	messageType := byte(binaryMessageType)
+	if eio != eioVersion4 {
+		if len(packet) == 0 || packet[0] < '0' || packet[0] > '9' {
+			return nil, errPacketWrong
+		}
+		messageType, packet = packet[0]-'0', packet[1:]
+	}
+
+	data, err := base64.StdEncoding.DecodeString(packet)
+	if err != nil {
+		return nil, errPacketWrong
+	}
+	return append([]byte{messageType}, data...), nil
+}
 
 // setHeaders into w
 func setHeaders(w http.ResponseWriter) {
@@ -45,6 +166,35 @@ func setHeaders(w http.ResponseWriter) {
 	w.Header().Set("Expires", "0")                                         // Proxies
 }
 
+// acceptsGzip checks if the request r advertises gzip content encoding support
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if i := strings.IndexByte(encoding, ';'); i != -1 {
+			encoding = encoding[:i]
+		}
+		if strings.EqualFold(strings.TrimSpace(encoding), "gzip") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeBody writes the response body into w, compressing it with gzip if compress is true
+func writeBody(w http.ResponseWriter, body []byte, compress bool) error {
+	if !compress {
+		_, err := w.Write(body)
+		return err
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Add("Vary", "Accept-Encoding")
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(body); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
 // PollingTransportParams represents XHR polling transport params
 type PollingTransportParams struct {
 	Headers http.Header
@@ -53,13 +203,14 @@ type PollingTransportParams struct {
 // sessions describes sessions needed for identifying polling connections with socket.io connections
 type sessions struct {
 	sync.Mutex
-	m      map[string]*PollingConnection
-	logger *zap.Logger
+	m       map[string]*PollingConnection
+	reaping bool // the idle sessions reaper is running
+	logger  logging.Logger
 }
 
 // Set sets sessionID to the given connection
 func (s *sessions) Set(sessionID string, conn *PollingConnection) {
-	s.logger.Debug("sessions.Set() fired with:", zap.String("sessionId", sessionID))
+	s.logger.Debug("sessions.Set() fired with:", "sessionId", sessionID)
 	s.Lock()
 	defer s.Unlock()
 	s.m[sessionID] = conn
@@ -67,7 +218,7 @@ func (s *sessions) Set(sessionID string, conn *PollingConnection) {
 
 // Delete the sessionID
 func (s *sessions) Delete(sessionID string) {
-	s.logger.Debug("sessions.Delete() fired with:", zap.String("sessionId", sessionID))
+	s.logger.Debug("sessions.Delete() fired with:", "sessionId", sessionID)
 	s.Lock()
 	defer s.Unlock()
 	delete(s.m, sessionID)
@@ -80,6 +231,37 @@ func (s *sessions) Get(sessionID string) *PollingConnection {
 	return s.m[sessionID]
 }
 
+// startReaping marks the reaper as running, it returns false if it's already running
+func (s *sessions) startReaping() bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.reaping {
+		return false
+	}
+	s.reaping = true
+	return true
+}
+
+// reapIdle closes and deletes the sessions idle for longer than timeout. It returns false and marks
+// the reaper as stopped if no sessions are left
+func (s *sessions) reapIdle(timeout time.Duration) bool {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for sessionID, conn := range s.m {
+		if conn.idle(now) > timeout {
+			s.logger.Debug("sessions.reapIdle() closes idle session:", "sessionId", sessionID)
+			delete(s.m, sessionID)
+			conn.close()
+		}
+	}
+	if len(s.m) == 0 {
+		s.reaping = false
+		return false
+	}
+	return true
+}
+
 // PollingTransport represens the XHR polling transport params
 type PollingTransport struct {
 	PingInterval   time.Duration
@@ -90,17 +272,37 @@ type PollingTransport struct {
 	Headers  http.Header
 	sessions sessions
 
-	logger *zap.Logger
+	// BufferSize is the size of the incoming packets queue of the connection, so the POST requests
+	// with several packets are not blocked until every packet is processed
+	BufferSize int
+
+	// WriteBufferSize is the size of the outgoing messages queue of the connection, all the queued messages
+	// are sent in a single response to the polling request. Zero or negative size means PlDefaultWriteBufferSize
+	WriteBufferSize int
+
+	// EnableGzip compresses responses with gzip if the client advertises support of it
+	EnableGzip bool
+
+	CheckOriginHandler func(r *http.Request) bool
+	CORS               *CORSOptions // cross-origin requests are not handled if nil
+
+	// SessionStore shares the sessions with the other nodes, the requests of their sessions are forwarded
+	// to them. NodeURL is the base URL of this node reachable by the other nodes, e.g. "http://10.0.0.1:8080"
+	SessionStore SessionStore
+	NodeURL      string
+
+	logger logging.Logger
 }
 
 // DefaultPollingTransport returns PollingTransport with default params
 func DefaultPollingTransport() *PollingTransport {
-	l, _ := zap.NewProduction()
+	l := logging.Log()
 	return &PollingTransport{
-		PingInterval:   PlDefaultPingInterval,
-		PingTimeout:    PlDefaultPingTimeout,
-		ReceiveTimeout: PlDefaultReceiveTimeout,
-		SendTimeout:    PlDefaultSendTimeout,
+		PingInterval:    PlDefaultPingInterval,
+		PingTimeout:     PlDefaultPingTimeout,
+		ReceiveTimeout:  PlDefaultReceiveTimeout,
+		SendTimeout:     PlDefaultSendTimeout,
+		WriteBufferSize: PlDefaultWriteBufferSize,
 		sessions: sessions{
 			Mutex:  sync.Mutex{},
 			m:      map[string]*PollingConnection{},
@@ -111,9 +313,10 @@ func DefaultPollingTransport() *PollingTransport {
 	}
 }
 
-func NewPollingTransport(logger *zap.Logger) *PollingTransport {
+func NewPollingTransport(logger logging.Logger) *PollingTransport {
 	t := DefaultPollingTransport()
-	t.logger = logger
+	t.logger = logging.OrNop(logger)
+	t.sessions.logger = t.logger
 	return t
 }
 
@@ -122,30 +325,97 @@ func (t *PollingTransport) Connect(url string) (Connection, error) {
 	return nil, nil
 }
 
+// checkOrigin checks the request r origin with CheckOriginHandler and CORS settings, responding with an error
+// if it's not allowed. CORS headers are set into w
+func (t *PollingTransport) checkOrigin(w http.ResponseWriter, r *http.Request) bool {
+	allowed := t.CheckOriginHandler == nil || t.CheckOriginHandler(r)
+	if allowed && t.CORS != nil {
+		allowed = t.CORS.setHeaders(w.Header(), r)
+	}
+	if allowed {
+		return true
+	}
+
+	t.logger.Warn("PollingTransport origin not allowed", "origin", r.Header.Get("Origin"))
+	http.Error(w, errOriginNotAllowed.Error(), http.StatusForbidden)
+	return false
+}
+
 // HandleConnection returns a pointer to a new Connection
 func (t *PollingTransport) HandleConnection(w http.ResponseWriter, r *http.Request) (Connection, error) {
-	return &PollingConnection{
+	if !t.checkOrigin(w, r) {
+		return nil, errOriginNotAllowed
+	}
+
+	conn := &PollingConnection{
 		Transport:  t,
-		eventsInC:  make(chan string),
-		eventsOutC: make(chan string),
-		errors:     make(chan string),
-	}, nil
+		eventsInC:  make(chan string, t.BufferSize),
+		eventsOutC: make(chan *pollingWrite, t.writeBufferSize()),
+		closedC:    make(chan struct{}),
+		eio:        r.URL.Query().Get("EIO"),
+		b64:        r.URL.Query().Get("b64") == "1",
+	}
+	conn.touch()
+	return conn, nil
+}
+
+// writeBufferSize returns the size of the outgoing messages queue of the connections
+func (t *PollingTransport) writeBufferSize() int {
+	if t.WriteBufferSize <= 0 {
+		return PlDefaultWriteBufferSize
+	}
+	return t.WriteBufferSize
 }
 
 // SetSid to the given sessionID and connection
 func (t *PollingTransport) SetSid(sessionID string, connection Connection) {
 	t.sessions.Set(sessionID, connection.(*PollingConnection))
 	connection.(*PollingConnection).sessionID = sessionID
+	t.register(sessionID)
+
+	if t.PingTimeout > 0 && t.sessions.startReaping() {
+		go t.reap()
+	}
+}
+
+// reap periodically closes the sessions without any requests for longer than PingTimeout,
+// e.g. the ones of the clients disappeared without closing the connection. It returns when no sessions are left
+func (t *PollingTransport) reap() {
+	ticker := time.NewTicker(t.PingTimeout / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !t.sessions.reapIdle(t.PingTimeout) {
+			return
+		}
+	}
 }
 
 // Serve is for receiving messages from client, simple decoding also here
 func (t *PollingTransport) Serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		t.logger.Debug("PollingTransport.Serve() is serving OPTIONS request")
+		if t.CORS == nil {
+			http.Error(w, errMethodNotAllowed.Error(), http.StatusMethodNotAllowed)
+			return
+		}
+		t.CORS.preflight(w, r)
+		return
+	}
+
 	sessionId := r.URL.Query().Get("sid")
 	conn := t.sessions.Get(sessionId)
 	if conn == nil {
+		t.Forward(w, r)
 		return
 	}
 
+	if !t.checkOrigin(w, r) {
+		return
+	}
+
+	conn.begin()
+	defer conn.end()
+
 	switch r.Method {
 	case http.MethodGet:
 		t.logger.Debug("PollingTransport.Serve() is serving GET request")
@@ -154,42 +424,141 @@ func (t *PollingTransport) Serve(w http.ResponseWriter, r *http.Request) {
 		bodyBytes, err := ioutil.ReadAll(r.Body)
 		r.Body.Close()
 		if err != nil {
-			t.logger.Warn("PollingTransport.Serve() error ioutil.ReadAll():", zap.Error(err))
+			t.logger.Warn("PollingTransport.Serve() error ioutil.ReadAll():", "err", err)
 			return
 		}
 
 		bodyString := string(bodyBytes)
-		t.logger.Debug("PollingTransport.Serve() POST bodyString before split:", zap.String("bodyString", bodyString))
-		index := strings.Index(bodyString, ":")
-		body := bodyString[index+1:]
+		if logging.DebugEnabled(t.logger) {
+			t.logger.Debug("PollingTransport.Serve() POST bodyString before split:", "bodyString", bodyString)
+		}
+		packets, err := decodePayload(bodyString, conn.eio)
+		if err != nil {
+			t.logger.Warn("PollingTransport.Serve() error decodePayload():", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		setHeaders(w)
 
-		t.logger.Debug("PollingTransport.Serve() POST body:", zap.String("body", body))
 		w.Write([]byte("ok"))
 		t.logger.Debug("PollingTransport.Serve() written POST response")
-		conn.eventsInC <- body
+		for _, packet := range packets {
+			if logging.DebugEnabled(t.logger) {
+				t.logger.Debug("PollingTransport.Serve() POST packet:", "packet", packet)
+			}
+			conn.eventsInC <- packet
+		}
 		t.logger.Debug("PollingTransport.Serve() sent to eventsInC")
 	}
 }
 
+// pollingWrite is a message written into the polling connection, the result of writing it
+// into the polling response is sent into errC, if it's not nil
+type pollingWrite struct {
+	message string
+	errC    chan error
+}
+
+// done reports the result err of writing the message
+func (pw *pollingWrite) done(err error) {
+	if pw.errC != nil {
+		pw.errC <- err
+	}
+}
+
 // PollingConnection represents a XHR polling connection
 type PollingConnection struct {
+	// the atomically accessed 64-bit fields go first, so they're 64-bit aligned on the 32-bit platforms
+	lastActivity   int64 // the unix time in nanoseconds of the last request
+	receiveTimeout int64 // in nanoseconds, zero means the transport one
+	sendTimeout    int64 // in nanoseconds, zero means the transport one
+
 	Transport  *PollingTransport
 	eventsInC  chan string
-	eventsOutC chan string
-	errors     chan string
+	eventsOutC chan *pollingWrite
 	sessionID  string
+	eio        string // engine.io protocol version requested by the client
+	b64        bool   // the engine.io v3 client requested base64 encoded binary packets
+
+	closedC   chan struct{} // closed when the session is reaped
+	closeOnce sync.Once
+
+	writeErr   error // the error of sending the queued messages, it's returned by the following writes
+	writeErrMu sync.Mutex
+
+	requests int32 // the number of requests being served, accessed atomically
+}
+
+// touch updates the last activity time of the connection
+func (polling *PollingConnection) touch() {
+	atomic.StoreInt64(&polling.lastActivity, time.Now().UnixNano())
+}
+
+// begin marks a request of the connection being served
+func (polling *PollingConnection) begin() {
+	atomic.AddInt32(&polling.requests, 1)
+	polling.touch()
+}
+
+// end marks a request of the connection served
+func (polling *PollingConnection) end() {
+	polling.touch()
+	atomic.AddInt32(&polling.requests, -1)
+}
+
+// idle returns how long the connection has no requests at the time now
+func (polling *PollingConnection) idle(now time.Time) time.Duration {
+	if atomic.LoadInt32(&polling.requests) > 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&polling.lastActivity)))
+}
+
+// SetReceiveTimeout sets the maximum time to wait for the incoming message, it's applied to the following reads
+func (polling *PollingConnection) SetReceiveTimeout(d time.Duration) {
+	atomic.StoreInt64(&polling.receiveTimeout, int64(d))
+}
+
+// SetSendTimeout sets the maximum time to wait for the outgoing message to be taken by the polling request,
+// it's applied to the following writes
+func (polling *PollingConnection) SetSendTimeout(d time.Duration) {
+	atomic.StoreInt64(&polling.sendTimeout, int64(d))
+}
+
+// receiveTimeoutOrDefault returns the receive timeout of the connection, it's the transport one if not set
+func (polling *PollingConnection) receiveTimeoutOrDefault() time.Duration {
+	if d := atomic.LoadInt64(&polling.receiveTimeout); d > 0 {
+		return time.Duration(d)
+	}
+	return polling.Transport.ReceiveTimeout
+}
+
+// sendTimeoutOrDefault returns the send timeout of the connection, it's the transport one if not set
+func (polling *PollingConnection) sendTimeoutOrDefault() time.Duration {
+	if d := atomic.LoadInt64(&polling.sendTimeout); d > 0 {
+		return time.Duration(d)
+	}
+	return polling.Transport.SendTimeout
+}
+
+// close unblocks the reads and writes of the connection, they return errSessionClosed
+func (polling *PollingConnection) close() {
+	polling.closeOnce.Do(func() { close(polling.closedC) })
 }
 
 // GetMessage waits for incoming message from the connection
 func (polling *PollingConnection) GetMessage() (string, error) {
 	select {
-	case <-time.After(polling.Transport.ReceiveTimeout):
+	case <-time.After(polling.receiveTimeoutOrDefault()):
 		polling.Transport.logger.Debug("PollingConnection.GetMessage() timed out")
 		return "", errGetMessageTimeout
+	case <-polling.closedC:
+		return "", errSessionClosed
 	case m := <-polling.eventsInC:
-		polling.Transport.logger.Debug("PollingConnection.GetMessage() received:", zap.String("m", m))
+		if logging.DebugEnabled(polling.Transport.logger) {
+			polling.Transport.logger.Debug("PollingConnection.GetMessage() received:", "m", m)
+		}
 		if m == protocol.MessageClose {
 			polling.Transport.logger.Debug("PollingConnection.GetMessage() received connection close")
 			return "", errReceivedConnectionClose
@@ -198,28 +567,157 @@ func (polling *PollingConnection) GetMessage() (string, error) {
 	}
 }
 
-// WriteMessage to the connection
+// WriteMessage queues the message to be sent in response to the polling request. It doesn't wait
+// for the message to be sent, the error of sending it is returned by the following writes
 func (polling *PollingConnection) WriteMessage(message string) error {
-	polling.Transport.logger.Debug("PollingConnection.WriteMessage() fired with:", zap.String("message", message))
-	polling.eventsOutC <- message
-	polling.Transport.logger.Debug("PollingConnection.WriteMessage() written to eventsOutC:", zap.String("message", message))
+	if logging.DebugEnabled(polling.Transport.logger) {
+		polling.Transport.logger.Debug("PollingConnection.WriteMessage() fired with:", "message", message)
+	}
+	if err := polling.writeError(); err != nil {
+		return err
+	}
+	select {
+	case polling.eventsOutC <- &pollingWrite{message: message}:
+		return nil
+	case <-time.After(polling.sendTimeoutOrDefault()):
+		polling.Transport.logger.Debug("PollingConnection.WriteMessage() timed out waiting for the polling request")
+		return errWriteMessageTimeout
+	case <-polling.closedC:
+		return errSessionClosed
+	}
+}
+
+// writeAndWait writes the message to the connection and waits for it to be sent
+func (polling *PollingConnection) writeAndWait(message string) error {
+	// every write gets its own result, so a polling request can't report the result of another one
+	pw := &pollingWrite{message: message, errC: make(chan error, 1)}
 	select {
-	case <-time.After(polling.Transport.SendTimeout):
+	case polling.eventsOutC <- pw:
+	case <-time.After(polling.sendTimeoutOrDefault()):
+		polling.Transport.logger.Debug("PollingConnection.writeAndWait() timed out waiting for the polling request")
 		return errWriteMessageTimeout
-	case errString := <-polling.errors:
-		if errString != noError {
-			polling.Transport.logger.Debug("PollingConnection.WriteMessage() failed to write with err:", zap.String("errString", errString))
-			return errors.New(errString)
+	case <-polling.closedC:
+		return errSessionClosed
+	}
+	// the buffered write of the closed session is never taken by a polling request
+	select {
+	case <-time.After(polling.sendTimeoutOrDefault()):
+		return errWriteMessageTimeout
+	case <-polling.closedC:
+		return errSessionClosed
+	case err := <-pw.errC:
+		if err != nil {
+			polling.Transport.logger.Debug("PollingConnection.writeAndWait() failed to write with err:", "err", err)
+			return err
 		}
 	}
 	return nil
 }
 
+// writeError returns the error of sending the queued messages, if any
+func (polling *PollingConnection) writeError() error {
+	polling.writeErrMu.Lock()
+	defer polling.writeErrMu.Unlock()
+	return polling.writeErr
+}
+
+// done reports the result err of sending the batch of writes
+func (polling *PollingConnection) done(batch []*pollingWrite, err error) {
+	if err != nil {
+		polling.writeErrMu.Lock()
+		polling.writeErr = err
+		polling.writeErrMu.Unlock()
+	}
+	for _, pw := range batch {
+		pw.done(err)
+	}
+}
+
+// Drain removes the messages queued to be sent and returns them, e.g. to send them with another connection.
+// The blank message closing the connection is left in the queue
+func (polling *PollingConnection) Drain() []string {
+	var messages []string
+	for {
+		select {
+		case pw := <-polling.eventsOutC:
+			if pw.message == protocol.MessageBlank {
+				polling.eventsOutC <- pw
+				return messages
+			}
+			messages = append(messages, pw.message)
+			pw.done(nil)
+		default:
+			return messages
+		}
+	}
+}
+
+// QueueLen returns the amount of the messages queued to be sent in response to the polling requests
+func (polling *PollingConnection) QueueLen() int { return len(polling.eventsOutC) }
+
+// SupportsBinary checks if binary messages can be exchanged with the client, they are base64 encoded.
+// Engine.io v4 clients always support them, earlier ones only if requested
+func (polling *PollingConnection) SupportsBinary() bool {
+	return polling.eio == eioVersion4 || polling.b64
+}
+
+// GetBinary waits for the incoming base64 encoded binary message from the connection
+func (polling *PollingConnection) GetBinary() ([]byte, error) {
+	if !polling.SupportsBinary() {
+		return nil, errBinaryMessage
+	}
+
+	m, err := polling.GetMessage()
+	if err != nil {
+		return nil, err
+	}
+	return decodeBase64(m, polling.eio)
+}
+
+// ReadMessage reads the following message from the connection, the base64 encoded binary message
+// is returned as the engine.io binary frame
+func (polling *PollingConnection) ReadMessage() (string, []byte, error) {
+	m, err := polling.GetMessage()
+	if err != nil || !polling.SupportsBinary() || !strings.HasPrefix(m, base64Prefix) {
+		return m, nil, err
+	}
+
+	frame, err := decodeBase64(m, polling.eio)
+	if err != nil {
+		return "", nil, err
+	}
+	return "", frame, nil
+}
+
+// WriteBinary writes the binary message data into the connection base64 encoded
+func (polling *PollingConnection) WriteBinary(data []byte) error {
+	if !polling.SupportsBinary() {
+		return errBinaryMessage
+	}
+	if len(data) == 0 {
+		return errPacketWrong
+	}
+	return polling.WriteMessage(encodeBase64(data, polling.eio))
+}
+
+// DecodeBinary returns the engine.io binary frame of the base64 encoded message m written by WriteBinary,
+// e.g. the one returned by Drain
+func (polling *PollingConnection) DecodeBinary(m string) ([]byte, error) {
+	return decodeBase64(m, polling.eio)
+}
+
 // Close the polling connection and delete session
 func (polling *PollingConnection) Close() error {
-	polling.Transport.logger.Debug("PollingConnection.Close() fired for session:", zap.String("sessionId", polling.sessionID))
-	err := polling.WriteMessage(protocol.MessageBlank)
+	polling.Transport.logger.Debug("PollingConnection.Close() fired for session:", "sessionId", polling.sessionID)
+	var err error
+	// the closed session, e.g. the reaped one, isn't polled anymore
+	select {
+	case <-polling.closedC:
+	default:
+		err = polling.writeAndWait(protocol.MessageBlank)
+	}
 	polling.Transport.sessions.Delete(polling.sessionID)
+	polling.Transport.unregister(polling.sessionID)
 	return err
 }
 
@@ -228,51 +726,63 @@ func (polling *PollingConnection) PingParams() (time.Duration, time.Duration) {
 	return polling.Transport.PingInterval, polling.Transport.PingTimeout
 }
 
-// PollingWriter for writing polling answer
+// PollingWriter for writing polling answer, all the queued messages are written at once
 func (polling *PollingConnection) PollingWriter(w http.ResponseWriter, r *http.Request) {
 	setHeaders(w)
+	var batch []*pollingWrite
 	select {
-	case <-time.After(polling.Transport.SendTimeout):
+	case <-time.After(polling.sendTimeoutOrDefault()):
 		polling.Transport.logger.Debug("PollingTransport.PollingWriter() timed out")
-		polling.errors <- noError
-	case message := <-polling.eventsOutC:
-		polling.Transport.logger.Debug("PollingTransport.PollingWriter() prepares to write message:", zap.String("message", message))
-		message = withLength(message)
-		if message == withLength(protocol.MessageBlank) {
-			polling.Transport.logger.Debug("PollingTransport.PollingWriter() writing 1:6")
-
-			hj, ok := w.(http.Hijacker)
-			if !ok {
-				http.Error(w, hijackingNotSupported, http.StatusInternalServerError)
-				return
-			}
+		return
+	case pw := <-polling.eventsOutC:
+		batch = polling.batch(pw)
+	}
 
-			conn, buffer, err := hj.Hijack()
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+	messages := make([]string, len(batch))
+	for i, pw := range batch {
+		messages[i] = pw.message
+	}
+	if logging.DebugEnabled(polling.Transport.logger) {
+		polling.Transport.logger.Debug("PollingTransport.PollingWriter() prepares to write messages:", "messages", messages)
+	}
+	payload := encodePayload(messages, polling.eio)
+
+	// the blank message closes the connection, the response isn't compressed so its length is known up front
+	closing := batch[len(batch)-1].message == protocol.MessageBlank
+	if closing {
+		polling.Transport.logger.Debug("PollingTransport.PollingWriter() writing blank message")
+		w.Header().Set("Connection", "close")
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+	}
 
-			defer conn.Close()
-
-			buffer.WriteString("HTTP/1.1 200 OK\r\n" +
-				"Cache-Control: no-cache, private\r\n" +
-				"Content-Length: 3\r\n" +
-				"Date: Mon, 24 Nov 2016 10:21:21 GMT\r\n\r\n")
-			buffer.WriteString(withLength(protocol.MessageBlank))
-			buffer.Flush()
-			polling.Transport.logger.Debug("PollingTransport.PollingWriter() hijack returns")
-			polling.errors <- noError
-			polling.eventsInC <- StopMessage
-		} else {
-			_, err := w.Write([]byte(message))
-			polling.Transport.logger.Debug("PollingTransport.PollingWriter() written message:", zap.String("message", message))
-			if err != nil {
-				polling.Transport.logger.Warn("PollingTransport.PollingWriter() failed to write message with err:", zap.Error(err))
-				polling.errors <- err.Error()
-				return
-			}
-			polling.errors <- noError
+	err := writeBody(w, []byte(payload), !closing && polling.Transport.EnableGzip && acceptsGzip(r))
+	if logging.DebugEnabled(polling.Transport.logger) {
+		polling.Transport.logger.Debug("PollingTransport.PollingWriter() written payload:", "payload", payload)
+	}
+	if err != nil {
+		polling.Transport.logger.Warn("PollingTransport.PollingWriter() failed to write message with err:", "err", err)
+	}
+	if flusher, ok := w.(http.Flusher); ok && closing && err == nil {
+		flusher.Flush()
+	}
+	polling.done(batch, err)
+
+	if closing {
+		polling.eventsInC <- StopMessage
+	}
+}
+
+// batch returns the write pw followed by the writes queued after it. The blank message closing
+// the connection ends the batch
+func (polling *PollingConnection) batch(pw *pollingWrite) []*pollingWrite {
+	batch := []*pollingWrite{pw}
+	for pw.message != protocol.MessageBlank {
+		select {
+		case pw = <-polling.eventsOutC:
+			batch = append(batch, pw)
+		default:
+			return batch
 		}
 	}
+	return batch
 }
@@ -3,20 +3,21 @@ package transport
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
-	"go.uber.org/zap"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"strings"
+	"net/url"
+	"sync"
 	"time"
 
+	"github.com/vanti-dev/golang-socketio/logging"
 	"github.com/vanti-dev/golang-socketio/protocol"
 )
 
 var (
-	errResponseIsNotOK       = errors.New("response body is not OK")
-	errAnswerNotOpenSequence = errors.New("not opensequence answer")
-	errAnswerNotOpenMessage  = errors.New("not openmessage answer")
+	errResponseIsNotOK       = newError(ErrHandshake, "response body is not OK")
+	errAnswerNotOpenSequence = newError(ErrHandshake, "not opensequence answer")
+	errAnswerNotOpenMessage  = newError(ErrHandshake, "not openmessage answer")
 )
 
 // openSequence represents a connection open sequence parameters
@@ -37,7 +38,10 @@ type PollingClientTransport struct {
 	Headers  http.Header
 	sessions sessions
 
-	logger *zap.Logger
+	// HTTPClient is used to perform requests if not nil, so a proxy, TLS or auth could be configured
+	HTTPClient *http.Client
+
+	logger logging.Logger
 }
 
 // DefaultPollingClientTransport returns client polling transport with default params
@@ -47,12 +51,13 @@ func DefaultPollingClientTransport() *PollingClientTransport {
 		PingTimeout:    PlDefaultPingTimeout,
 		ReceiveTimeout: PlDefaultReceiveTimeout,
 		SendTimeout:    PlDefaultSendTimeout,
+		logger:         logging.Log(),
 	}
 }
 
-func NewPollingClientTransport(logger *zap.Logger) *PollingClientTransport {
+func NewPollingClientTransport(logger logging.Logger) *PollingClientTransport {
 	t := DefaultPollingClientTransport()
-	t.logger = logger
+	t.logger = logging.OrNop(logger)
 	return t
 }
 
@@ -68,27 +73,25 @@ func (t *PollingClientTransport) Serve(w http.ResponseWriter, r *http.Request) {
 func (t *PollingClientTransport) SetSid(sid string, conn Connection) {}
 
 // Connect to server, perform 3 HTTP requests in connecting sequence
-func (t *PollingClientTransport) Connect(url string) (Connection, error) {
-	polling := &PollingClientConnection{transport: t, client: &http.Client{}, url: url}
+func (t *PollingClientTransport) Connect(rawURL string) (Connection, error) {
+	client := t.HTTPClient
+	if client == nil {
+		client = &http.Client{}
+	}
 
-	resp, err := polling.client.Get(polling.url)
-	if err != nil {
-		t.logger.Debug("PollingConnection.Connect() error polling.client.Get() 1:", zap.Error(err))
-		return nil, err
+	polling := &PollingClientConnection{transport: t, client: client, url: rawURL}
+	if u, err := url.Parse(rawURL); err == nil {
+		polling.eio = u.Query().Get("EIO")
 	}
 
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	body, err := polling.GetMessage()
 	if err != nil {
-		t.logger.Debug("PollingConnection.Connect() error ioutil.ReadAll() 1:", zap.Error(err))
+		t.logger.Debug("PollingConnection.Connect() error polling.GetMessage() 1:", "err", err)
 		return nil, err
 	}
+	t.logger.Debug("PollingConnection.Connect() body 1:", "body", body)
 
-	resp.Body.Close()
-	bodyString := string(bodyBytes)
-	t.logger.Debug("PollingConnection.Connect() bodyString 1:", zap.String("bodyString", bodyString))
-
-	body := bodyString[strings.Index(bodyString, ":")+1:]
-	if string(body[0]) != protocol.MessageOpen {
+	if len(body) == 0 || string(body[0]) != protocol.MessageOpen {
 		return nil, errAnswerNotOpenSequence
 	}
 
@@ -96,29 +99,19 @@ func (t *PollingClientTransport) Connect(url string) (Connection, error) {
 	var openSequence openSequence
 
 	if err := json.Unmarshal(bodyBytes2, &openSequence); err != nil {
-		t.logger.Debug("PollingConnection.Connect() error json.Unmarshal() 1:", zap.Error(err))
+		t.logger.Debug("PollingConnection.Connect() error json.Unmarshal() 1:", "err", err)
 		return nil, err
 	}
 
 	polling.url += "&sid=" + openSequence.Sid
-	t.logger.Debug("PollingConnection.Connect() polling.url 1:", zap.String("url", polling.url))
-
-	resp, err = polling.client.Get(polling.url)
-	if err != nil {
-		t.logger.Debug("PollingConnection.Connect() error plc.client.Get() 2:", zap.Error(err))
-		return nil, err
-	}
+	t.logger.Debug("PollingConnection.Connect() polling.url 1:", "url", polling.url)
 
-	bodyBytes, err = ioutil.ReadAll(resp.Body)
+	body, err = polling.GetMessage()
 	if err != nil {
-		t.logger.Debug("PollingConnection.Connect() error ioutil.ReadAll() 2:", zap.Error(err))
+		t.logger.Debug("PollingConnection.Connect() error polling.GetMessage() 2:", "err", err)
 		return nil, err
 	}
-
-	resp.Body.Close()
-	bodyString = string(bodyBytes)
-	t.logger.Debug("PollingConnection.Connect() bodyString 2:", zap.String("bodyString", bodyString))
-	body = bodyString[strings.Index(bodyString, ":")+1:]
+	t.logger.Debug("PollingConnection.Connect() body 2:", "body", body)
 
 	if body != protocol.MessageEmpty {
 		return nil, errAnswerNotOpenMessage
@@ -133,47 +126,92 @@ type PollingClientConnection struct {
 	client    *http.Client
 	url       string
 	sid       string
+	eio       string   // engine.io protocol version requested in the url
+	pending   []string // packets received with the previous payload and not returned yet
+
+	// getMu allows only one long-polling GET request at a time, POST requests are not blocked by it
+	getMu sync.Mutex
+}
+
+// do performs the request with the given method and body to the connection url with the transport headers
+func (polling *PollingClientConnection) do(method string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, polling.url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range polling.transport.Headers {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return polling.client.Do(req)
 }
 
-// GetMessage performs a GET request to wait for the following message
+// GetMessage returns the following packet, performing a GET request to wait for it if there is no
+// packet left from the previous payload
 func (polling *PollingClientConnection) GetMessage() (string, error) {
 	polling.transport.logger.Debug("PollingConnection.GetMessage() fired")
 
-	resp, err := polling.client.Get(polling.url)
+	polling.getMu.Lock()
+	defer polling.getMu.Unlock()
+
+	if len(polling.pending) > 0 {
+		packet := polling.pending[0]
+		polling.pending = polling.pending[1:]
+		return packet, nil
+	}
+
+	resp, err := polling.do(http.MethodGet, nil)
 	if err != nil {
-		polling.transport.logger.Warn("PollingConnection.GetMessage() error polling.client.Get():", zap.Error(err))
+		polling.transport.logger.Warn("PollingConnection.GetMessage() error polling.do():", "err", err)
 		return "", err
 	}
+	defer resp.Body.Close()
 
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		polling.transport.logger.Warn("PollingConnection.GetMessage() error ioutil.ReadAll():", zap.Error(err))
+		polling.transport.logger.Warn("PollingConnection.GetMessage() error ioutil.ReadAll():", "err", err)
 		return "", err
 	}
 
 	bodyString := string(bodyBytes)
-	polling.transport.logger.Debug("PollingConnection.GetMessage() ", zap.String("bodyString", bodyString))
-	index := strings.Index(bodyString, ":")
+	if logging.DebugEnabled(polling.transport.logger) {
+		polling.transport.logger.Debug("PollingConnection.GetMessage() ", "bodyString", bodyString)
+	}
 
-	body := bodyString[index+1:]
-	return body, nil
+	packets, err := decodePayload(bodyString, polling.eio)
+	if err != nil {
+		polling.transport.logger.Warn("PollingConnection.GetMessage() error decodePayload():", "err", err)
+		return "", err
+	}
+	if len(packets) == 0 {
+		return "", nil
+	}
+
+	polling.pending = packets[1:]
+	return packets[0], nil
 }
 
 // WriteMessage performs a POST request to send a message to server
 func (polling *PollingClientConnection) WriteMessage(m string) error {
-	mWrite := withLength(m)
-	polling.transport.logger.Debug("PollingConnection.WriteMessage() fired, msgToWrite:", zap.String("mWrite", mWrite))
+	mWrite := encodePayload([]string{m}, polling.eio)
+	if logging.DebugEnabled(polling.transport.logger) {
+		polling.transport.logger.Debug("PollingConnection.WriteMessage() fired, msgToWrite:", "mWrite", mWrite)
+	}
 	mJSON := []byte(mWrite)
 
-	resp, err := polling.client.Post(polling.url, "application/json", bytes.NewBuffer(mJSON))
+	resp, err := polling.do(http.MethodPost, bytes.NewBuffer(mJSON))
 	if err != nil {
-		polling.transport.logger.Debug("PollingConnection.WriteMessage() error polling.client.Post():", zap.Error(err))
+		polling.transport.logger.Debug("PollingConnection.WriteMessage() error polling.do():", "err", err)
 		return err
 	}
 
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		polling.transport.logger.Debug("PollingConnection.WriteMessage() error ioutil.ReadAll():", zap.Error(err))
+		polling.transport.logger.Debug("PollingConnection.WriteMessage() error ioutil.ReadAll():", "err", err)
 		return err
 	}
 
@@ -186,6 +224,12 @@ func (polling *PollingClientConnection) WriteMessage(m string) error {
 	return nil
 }
 
+// GetBinary is not supported by the polling client connection
+func (polling *PollingClientConnection) GetBinary() ([]byte, error) { return nil, errBinaryMessage }
+
+// WriteBinary is not supported by the polling client connection
+func (polling *PollingClientConnection) WriteBinary([]byte) error { return errBinaryMessage }
+
 // Close the client connection gracefully
 func (polling *PollingClientConnection) Close() error {
 	return polling.WriteMessage(protocol.MessageClose)
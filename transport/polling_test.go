@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodePayloadCountsUTF16(t *testing.T) {
+	tests := []struct {
+		packets []string
+		want    string
+	}{
+		{[]string{"4hello"}, "6:4hello"},
+		{[]string{"4é"}, "2:4é"},
+		{[]string{"4😀"}, "3:4😀"},
+		{[]string{"4😀", "2"}, "3:4😀1:2"},
+	}
+	for _, tt := range tests {
+		if got := encodePayload(tt.packets, "3"); got != tt.want {
+			t.Errorf("encodePayload(%q) = %q, want %q", tt.packets, got, tt.want)
+		}
+	}
+}
+
+func TestDecodePayloadCountsUTF16(t *testing.T) {
+	packets, err := decodePayload("3:4😀1:2", "3")
+	if err != nil {
+		t.Fatalf("decodePayload() error = %v", err)
+	}
+	if want := []string{"4😀", "2"}; !reflect.DeepEqual(packets, want) {
+		t.Fatalf("decodePayload() = %q, want %q", packets, want)
+	}
+
+	for _, payload := range []string{"2:4😀", "4:4😀", "x:4"} {
+		if _, err := decodePayload(payload, "3"); err == nil {
+			t.Errorf("decodePayload(%q) error = nil, want errWrongPayload", payload)
+		}
+	}
+
+	for _, eio := range []string{"3", "4"} {
+		if packets, err := decodePayload("", eio); err != nil || len(packets) != 0 {
+			t.Errorf("decodePayload(\"\", %s) = %q, %v, want no packets", eio, packets, err)
+		}
+	}
+}
+
+func TestCloseReapedSession(t *testing.T) {
+	tr := DefaultPollingTransport()
+	tr.PingTimeout = 20 * time.Millisecond
+	tr.SendTimeout = 5 * time.Second
+
+	conn, err := tr.HandleConnection(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?EIO=4&transport=polling", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	polling := conn.(*PollingConnection)
+	tr.SetSid("sid", polling)
+
+	select {
+	case <-polling.closedC:
+	case <-time.After(time.Second):
+		t.Fatal("the idle session wasn't reaped")
+	}
+
+	start := time.Now()
+	polling.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Close of the reaped session took %v", elapsed)
+	}
+}
+
+func TestWriteAndWaitReturnsOnClose(t *testing.T) {
+	tr := DefaultPollingTransport()
+	tr.SendTimeout = 5 * time.Second
+
+	conn, err := tr.HandleConnection(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?EIO=4&transport=polling", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	polling := conn.(*PollingConnection)
+
+	errC := make(chan error, 1)
+	go func() { errC <- polling.writeAndWait("6") }()
+	// the write is buffered, so it's queued before the session is closed
+	time.Sleep(20 * time.Millisecond)
+	polling.close()
+
+	select {
+	case err := <-errC:
+		if err != errSessionClosed {
+			t.Fatalf("err = %v, want %v", err, errSessionClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("writeAndWait didn't return on close")
+	}
+}
+
+func TestDefaultWriteBufferSize(t *testing.T) {
+	tr := DefaultPollingTransport()
+	tr.WriteBufferSize = 0
+
+	conn, err := tr.HandleConnection(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?EIO=4&transport=polling", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := cap(conn.(*PollingConnection).eventsOutC); size != PlDefaultWriteBufferSize {
+		t.Fatalf("outgoing queue size = %d, want %d", size, PlDefaultWriteBufferSize)
+	}
+}
@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// headerForwarded marks the request forwarded to the node serving its session, so it isn't forwarded again
+const headerForwarded = "X-Socketio-Forwarded"
+
+// SessionStore shares the polling sessions between the nodes of a multi-node deployment, e.g. with Redis.
+// The requests of the sessions served by another node are forwarded to it, so the load balancer
+// doesn't need sticky sessions
+type SessionStore interface {
+	// Register records the session served by the node with the given base URL
+	Register(sessionID, nodeURL string) error
+
+	// Unregister removes the session record
+	Unregister(sessionID string) error
+
+	// Lookup returns the base URL of the node serving the session, ok is false if the session is unknown
+	Lookup(sessionID string) (nodeURL string, ok bool, err error)
+}
+
+// register records the session of this node in the SessionStore, if it's set
+func (t *PollingTransport) register(sessionID string) {
+	if t.SessionStore == nil {
+		return
+	}
+	if err := t.SessionStore.Register(sessionID, t.NodeURL); err != nil {
+		t.logger.Warn("PollingTransport.register() failed to register session:", "sessionId", sessionID, "err", err)
+	}
+}
+
+// unregister removes the session of this node from the SessionStore, if it's set
+func (t *PollingTransport) unregister(sessionID string) {
+	if t.SessionStore == nil {
+		return
+	}
+	if err := t.SessionStore.Unregister(sessionID); err != nil {
+		t.logger.Warn("PollingTransport.unregister() failed to unregister session:", "sessionId", sessionID, "err", err)
+	}
+}
+
+// Forward proxies the request r of the session served by another node to that node, found in the SessionStore.
+// It returns false if the request isn't forwarded: the session is served by this node, it's unknown
+// or the request has been forwarded already. Websocket upgrade requests are forwarded too
+func (t *PollingTransport) Forward(w http.ResponseWriter, r *http.Request) bool {
+	sessionID := r.URL.Query().Get("sid")
+	if t.SessionStore == nil || sessionID == "" || r.Header.Get(headerForwarded) != "" || t.sessions.Get(sessionID) != nil {
+		return false
+	}
+
+	nodeURL, ok, err := t.SessionStore.Lookup(sessionID)
+	if err != nil {
+		t.logger.Warn("PollingTransport.Forward() failed to look up session:", "sessionId", sessionID, "err", err)
+		return false
+	}
+	if !ok || nodeURL == t.NodeURL {
+		return false
+	}
+
+	target, err := url.Parse(nodeURL)
+	if err != nil {
+		t.logger.Warn("PollingTransport.Forward() invalid node URL:", "nodeURL", nodeURL, "err", err)
+		return false
+	}
+
+	t.logger.Debug("PollingTransport.Forward() forwards session:", "sessionId", sessionID, "nodeURL", nodeURL)
+	r.Header.Set(headerForwarded, t.NodeURL)
+	httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, r)
+	return true
+}
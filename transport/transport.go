@@ -1,18 +1,38 @@
 package transport
 
 import (
+	"errors"
+	"io"
+	"net"
 	"net/http"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 // Connection represents an end-point connection with transport
 type Connection interface {
 	GetMessage() (message string, err error)
 	WriteMessage(message string) error
+	GetBinary() (data []byte, err error)
+	WriteBinary(data []byte) error
 	Close() error
 	PingParams() (interval, timeout time.Duration)
 }
 
+// MessageReader is implemented by the connections reading both the text and the binary messages in order,
+// the binary message is returned as the engine.io binary frame starting with the message type
+type MessageReader interface {
+	ReadMessage() (text string, frame []byte, err error)
+}
+
+// TimeoutSetter is implemented by the connections whose timeouts can be adjusted individually, the transport
+// ReceiveTimeout and SendTimeout are used until they're set
+type TimeoutSetter interface {
+	SetReceiveTimeout(d time.Duration) // maximum time to wait for the incoming message
+	SetSendTimeout(d time.Duration)    // maximum time to wait for the outgoing message to be written
+}
+
 // Transport represents a connection transport
 type Transport interface {
 	Connect(url string) (conn Connection, err error)
@@ -20,3 +40,22 @@ type Transport interface {
 	Serve(w http.ResponseWriter, r *http.Request)
 	SetSid(sid string, conn Connection)
 }
+
+// IsClosedByPeer checks if the error err returned by the connection means it was closed by the other side
+func IsClosedByPeer(err error) bool {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		switch closeErr.Code {
+		case websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived:
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, errReceivedConnectionClose) || errors.Is(err, io.EOF)
+}
+
+// IsTimeout checks if the error err returned by the connection means nothing was received in time
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, ErrTimeout) || errors.As(err, &netErr) && netErr.Timeout()
+}
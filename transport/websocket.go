@@ -1,14 +1,19 @@
 package transport
 
 import (
+	"context"
 	"crypto/tls"
-	"errors"
-	"go.uber.org/zap"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/vanti-dev/golang-socketio/logging"
 )
 
 const (
@@ -19,6 +24,8 @@ const (
 	wsDefaultReceiveTimeout = 60 * time.Second
 	wsDefaultSendTimeout    = 60 * time.Second
 	wsDefaultBufferSize     = 1024 * 32
+	wsDefaultCloseTimeout   = time.Second
+	wsDefaultDialTimeout    = 45 * time.Second
 )
 
 // WebsocketTransportParams is a parameters for getting non-default websocket transport
@@ -28,11 +35,12 @@ type WebsocketTransportParams struct {
 }
 
 var (
-	errBinaryMessage     = errors.New("binary messages are not supported")
-	errBadBuffer         = errors.New("buffer error")
-	errPacketWrong       = errors.New("wrong packet type error")
-	errMethodNotAllowed  = errors.New("method not allowed")
-	errHttpUpgradeFailed = errors.New("http upgrade failed")
+	errBinaryMessage     = newError(ErrProtocol, "binary messages are not supported")
+	errBadBuffer         = newError(ErrProtocol, "buffer error")
+	errPacketWrong       = newError(ErrProtocol, "wrong packet type error")
+	errMethodNotAllowed  = newError(ErrHandshake, "method not allowed")
+	errHttpUpgradeFailed = newError(ErrHandshake, "http upgrade failed")
+	errMessageTooLarge   = newError(ErrProtocol, "message is too large")
 )
 
 // WebsocketTransport implements websocket transport
@@ -41,59 +49,114 @@ type WebsocketTransport struct {
 	PingTimeout    time.Duration
 	ReceiveTimeout time.Duration
 	SendTimeout    time.Duration
+	CloseTimeout   time.Duration // maximum time to wait for the peer's close frame when closing the connection
+
+	// HandshakeTimeout bounds the client connection attempt, including the TCP and TLS handshakes
+	// and the websocket upgrade, zero means no limit
+	HandshakeTimeout time.Duration
 
 	BufferSize      int
+	MaxMessageSize  int64 // maximum size of an inbound message in bytes, zero means no limit, it's the default
 	Headers         http.Header
 	TLSClientConfig *tls.Config
 
+	// Proxy returns the proxy the client connects through for the given request, nil result or function means
+	// the direct connection. The default transport uses the HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// EnableCompression negotiates permessage-deflate with the peer, it falls back to uncompressed
+	// messages if the peer doesn't support it
+	EnableCompression bool
+	// CompressionLevel is a flate compression level of the negotiated compression, zero means the default one
+	CompressionLevel int
+
+	// Subprotocols are the supported subprotocols in order of preference, the server selects the first one
+	// requested by the client, the client requests all of them
+	Subprotocols []string
+
 	CheckOriginHandler func(r *http.Request) bool
-	logger             *zap.Logger
+	// VerifyConnection is called before the upgrade, e.g. to check the client TLS certificate in r.TLS.
+	// The upgrade is rejected with 403 status if it returns an error
+	VerifyConnection func(r *http.Request) error
+
+	logger logging.Logger
 }
 
 // DefaultWebsocketTransport returns websocket connection with default params
 func DefaultWebsocketTransport() *WebsocketTransport {
-	l, _ := zap.NewProduction()
+	l := logging.Log()
 	return &WebsocketTransport{
 		PingInterval:   wsDefaultPingInterval,
 		PingTimeout:    wsDefaultPingTimeout,
 		ReceiveTimeout: wsDefaultReceiveTimeout,
 		SendTimeout:    wsDefaultSendTimeout,
+		CloseTimeout:   wsDefaultCloseTimeout,
 		BufferSize:     wsDefaultBufferSize,
 		logger:         l,
+
+		HandshakeTimeout: wsDefaultDialTimeout,
+		Proxy:            http.ProxyFromEnvironment,
 	}
 }
 
 // NewWebsocketTransport returns websocket transport with given params
-func NewWebsocketTransport(params WebsocketTransportParams, originHandler func(r *http.Request) bool, logger *zap.Logger) *WebsocketTransport {
+func NewWebsocketTransport(params WebsocketTransportParams, originHandler func(r *http.Request) bool, logger logging.Logger) *WebsocketTransport {
 	tr := DefaultWebsocketTransport()
 	tr.Headers = params.Headers
 	tr.TLSClientConfig = params.TLSClientConfig
 	tr.CheckOriginHandler = originHandler
-	tr.logger = logger
+	tr.logger = logging.OrNop(logger)
 	return tr
 }
 
-// Connect to the given url
+// Connect to the given url, the attempt is bounded by HandshakeTimeout
 func (t *WebsocketTransport) Connect(url string) (Connection, error) {
-	dialer := websocket.Dialer{TLSClientConfig: t.TLSClientConfig}
-	socket, _, err := dialer.Dial(url, t.Headers)
+	return t.ConnectContext(context.Background(), url)
+}
+
+// ConnectContext connects to the given url, the attempt is aborted when ctx is done
+// or HandshakeTimeout elapses, whichever happens first
+func (t *WebsocketTransport) ConnectContext(ctx context.Context, rawURL string) (Connection, error) {
+	dialer := websocket.Dialer{
+		TLSClientConfig:   t.TLSClientConfig,
+		EnableCompression: t.EnableCompression,
+		Subprotocols:      t.Subprotocols,
+		HandshakeTimeout:  t.HandshakeTimeout,
+		Proxy:             t.Proxy,
+	}
+	socket, resp, err := dialer.DialContext(ctx, rawURL, t.Headers)
 	if err != nil {
 		return nil, err
 	}
-	return &WebsocketConnection{socket, t}, nil
+	ws := newWebsocketConnection(socket, t)
+	ws.handshakeResponse = resp
+	if u, err := url.Parse(rawURL); err == nil {
+		ws.eio = u.Query().Get("EIO")
+	}
+	return ws, nil
 }
 
 // HandleConnection
 func (t *WebsocketTransport) HandleConnection(w http.ResponseWriter, r *http.Request) (Connection, error) {
-	t.logger.Debug("HandleConnection", zap.Any("r.Method", r.Method))
+	t.logger.Debug("HandleConnection", "r.Method", r.Method)
 	if r.Method != http.MethodGet {
 		http.Error(w, upgradeFailed+errMethodNotAllowed.Error(), http.StatusServiceUnavailable)
 		return nil, errMethodNotAllowed
 	}
 
+	if t.VerifyConnection != nil {
+		if err := t.VerifyConnection(r); err != nil {
+			t.logger.Warn("connection verification failed", "err", err)
+			http.Error(w, upgradeFailed+err.Error(), http.StatusForbidden)
+			return nil, err
+		}
+	}
+
 	u := &websocket.Upgrader{
-		ReadBufferSize:  t.BufferSize,
-		WriteBufferSize: t.BufferSize,
+		ReadBufferSize:    t.BufferSize,
+		WriteBufferSize:   t.BufferSize,
+		EnableCompression: t.EnableCompression,
+		Subprotocols:      t.Subprotocols,
 	}
 	if t.CheckOriginHandler != nil {
 		u.CheckOrigin = t.CheckOriginHandler
@@ -101,12 +164,14 @@ func (t *WebsocketTransport) HandleConnection(w http.ResponseWriter, r *http.Req
 
 	socket, err := u.Upgrade(w, r, nil)
 	if err != nil {
-		t.logger.Warn("couldn't upgrade", zap.Error(err))
+		t.logger.Warn("couldn't upgrade", "err", err)
 		http.Error(w, upgradeFailed+err.Error(), http.StatusServiceUnavailable)
 		return nil, errHttpUpgradeFailed
 	}
 
-	return &WebsocketConnection{socket, t}, nil
+	ws := newWebsocketConnection(socket, t)
+	ws.eio = r.URL.Query().Get("EIO")
+	return ws, nil
 }
 
 // Serve does nothing here. Websocket connection does not require any additional processing
@@ -117,19 +182,89 @@ func (t *WebsocketTransport) SetSid(string, Connection) {}
 
 // WebsocketConnection represents websocket connection
 type WebsocketConnection struct {
+	// the atomically accessed 64-bit fields go first, so they're 64-bit aligned on the 32-bit platforms
+	receiveTimeout int64 // in nanoseconds, zero means the transport one
+	sendTimeout    int64 // in nanoseconds, zero means the transport one
+
 	socket    *websocket.Conn
 	transport *WebsocketTransport
+	writeMu   sync.Mutex // the socket supports only one concurrent writer
+
+	readDoneC    chan struct{} // closed when reading from the socket fails, e.g. on the peer's close frame
+	readDoneOnce sync.Once
+
+	handshakeResponse *http.Response // the server response to the client upgrade request, nil on the server side
+	eio               string         // engine.io protocol version of the connection
+}
+
+// newWebsocketConnection returns a connection for the given socket
+func newWebsocketConnection(socket *websocket.Conn, t *WebsocketTransport) *WebsocketConnection {
+	ws := &WebsocketConnection{socket: socket, transport: t, readDoneC: make(chan struct{})}
+	// websocket level pongs prove the peer is alive, so extend the read deadline
+	socket.SetPongHandler(func(string) error {
+		return socket.SetReadDeadline(time.Now().Add(ws.receiveTimeoutOrDefault()))
+	})
+	if t.MaxMessageSize > 0 {
+		socket.SetReadLimit(t.MaxMessageSize)
+	}
+	// compression level is applied only if the compression was negotiated
+	if t.EnableCompression && t.CompressionLevel != 0 {
+		if err := socket.SetCompressionLevel(t.CompressionLevel); err != nil {
+			t.logger.Warn("newWebsocketConnection() can't set compression level", "err", err)
+		}
+	}
+	return ws
+}
+
+// HandshakeResponse returns the server response to the upgrade request of the client connection, e.g. to read
+// its headers. Its body is already consumed. It's nil for the connections accepted by the server
+func (ws *WebsocketConnection) HandshakeResponse() *http.Response { return ws.handshakeResponse }
+
+// SetReceiveTimeout sets the maximum time to wait for the incoming message, it's applied to the following reads
+func (ws *WebsocketConnection) SetReceiveTimeout(d time.Duration) {
+	atomic.StoreInt64(&ws.receiveTimeout, int64(d))
 }
 
+// SetSendTimeout sets the maximum time to wait for the outgoing message to be written, it's applied
+// to the following writes
+func (ws *WebsocketConnection) SetSendTimeout(d time.Duration) {
+	atomic.StoreInt64(&ws.sendTimeout, int64(d))
+}
+
+// receiveTimeoutOrDefault returns the receive timeout of the connection, it's the transport one if not set
+func (ws *WebsocketConnection) receiveTimeoutOrDefault() time.Duration {
+	if d := atomic.LoadInt64(&ws.receiveTimeout); d > 0 {
+		return time.Duration(d)
+	}
+	return ws.transport.ReceiveTimeout
+}
+
+// sendTimeoutOrDefault returns the send timeout of the connection, it's the transport one if not set
+func (ws *WebsocketConnection) sendTimeoutOrDefault() time.Duration {
+	if d := atomic.LoadInt64(&ws.sendTimeout); d > 0 {
+		return time.Duration(d)
+	}
+	return ws.transport.SendTimeout
+}
+
+// readDone signals that no more messages can be read from the socket
+func (ws *WebsocketConnection) readDone() {
+	ws.readDoneOnce.Do(func() { close(ws.readDoneC) })
+}
+
+// Subprotocol returns the negotiated subprotocol, it's empty if no subprotocol was negotiated
+func (ws *WebsocketConnection) Subprotocol() string { return ws.socket.Subprotocol() }
+
 // GetMessage from the connection
 func (ws *WebsocketConnection) GetMessage() (string, error) {
 	ws.transport.logger.Debug("WebsocketConnection.GetMessage() fired")
-	ws.socket.SetReadDeadline(time.Now().Add(ws.transport.ReceiveTimeout))
+	ws.socket.SetReadDeadline(time.Now().Add(ws.receiveTimeoutOrDefault()))
 
 	msgType, reader, err := ws.socket.NextReader()
 	if err != nil {
-		ws.transport.logger.Debug("WebsocketConnection.GetMessage() ws.socket.NextReader() err:", zap.Error(err))
-		return "", err
+		ws.transport.logger.Debug("WebsocketConnection.GetMessage() ws.socket.NextReader() err:", "err", err)
+		ws.readDone()
+		return "", wrapError(err)
 	}
 
 	// supports only text messages exchange
@@ -138,14 +273,16 @@ func (ws *WebsocketConnection) GetMessage() (string, error) {
 		return "", errBinaryMessage
 	}
 
-	data, err := ioutil.ReadAll(reader)
+	data, err := ws.readAll(reader)
 	if err != nil {
-		ws.transport.logger.Debug("WebsocketConnection.GetMessage() returns errBadBuffer")
-		return "", errBadBuffer
+		ws.transport.logger.Debug("WebsocketConnection.GetMessage() ws.readAll() err:", "err", err)
+		return "", err
 	}
 
 	text := string(data)
-	ws.transport.logger.Debug("WebsocketConnection.GetMessage() text:", zap.String("text", text))
+	if logging.DebugEnabled(ws.transport.logger) {
+		ws.transport.logger.Debug("WebsocketConnection.GetMessage() text:", "text", text)
+	}
 
 	// empty messages are not allowed
 	if len(text) == 0 {
@@ -156,27 +293,176 @@ func (ws *WebsocketConnection) GetMessage() (string, error) {
 	return text, nil
 }
 
+// GetBinary reads the following binary message from the connection, it's returned as the engine.io binary frame
+// starting with the message type
+func (ws *WebsocketConnection) GetBinary() ([]byte, error) {
+	ws.transport.logger.Debug("WebsocketConnection.GetBinary() fired")
+	ws.socket.SetReadDeadline(time.Now().Add(ws.receiveTimeoutOrDefault()))
+
+	msgType, reader, err := ws.socket.NextReader()
+	if err != nil {
+		ws.transport.logger.Debug("WebsocketConnection.GetBinary() ws.socket.NextReader() err:", "err", err)
+		ws.readDone()
+		return nil, wrapError(err)
+	}
+
+	if msgType != websocket.BinaryMessage {
+		ws.transport.logger.Debug("WebsocketConnection.GetBinary() returns errPacketWrong")
+		return nil, errPacketWrong
+	}
+
+	data, err := ws.readAll(reader)
+	if err != nil {
+		ws.transport.logger.Debug("WebsocketConnection.GetBinary() ws.readAll() err:", "err", err)
+		return nil, err
+	}
+
+	return ws.binaryFrame(data), nil
+}
+
+// ReadMessage reads the following text or binary message from the connection, the binary one is returned
+// as the engine.io binary frame starting with the message type
+func (ws *WebsocketConnection) ReadMessage() (string, []byte, error) {
+	ws.transport.logger.Debug("WebsocketConnection.ReadMessage() fired")
+	ws.socket.SetReadDeadline(time.Now().Add(ws.receiveTimeoutOrDefault()))
+
+	msgType, reader, err := ws.socket.NextReader()
+	if err != nil {
+		ws.transport.logger.Debug("WebsocketConnection.ReadMessage() ws.socket.NextReader() err:", "err", err)
+		ws.readDone()
+		return "", nil, wrapError(err)
+	}
+
+	data, err := ws.readAll(reader)
+	if err != nil {
+		ws.transport.logger.Debug("WebsocketConnection.ReadMessage() ws.readAll() err:", "err", err)
+		return "", nil, err
+	}
+
+	if msgType == websocket.BinaryMessage {
+		return "", ws.binaryFrame(data), nil
+	}
+	// empty messages are not allowed
+	if len(data) == 0 {
+		return "", nil, errPacketWrong
+	}
+	return string(data), nil, nil
+}
+
+// binaryFrame returns the engine.io binary frame of the binary message data
+func (ws *WebsocketConnection) binaryFrame(data []byte) []byte {
+	// engine.io v4 binary frames carry only the data of the message
+	if ws.eio == eioVersion4 {
+		return append([]byte{binaryMessageType}, data...)
+	}
+	return data
+}
+
+// readAll reads the whole message from reader, if the message exceeds MaxMessageSize
+// the connection is closed and errMessageTooLarge is returned
+func (ws *WebsocketConnection) readAll(reader io.Reader) ([]byte, error) {
+	maxSize := ws.transport.MaxMessageSize
+	if maxSize > 0 {
+		reader = io.LimitReader(reader, maxSize+1)
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err == websocket.ErrReadLimit || (maxSize > 0 && int64(len(data)) > maxSize) {
+		ws.transport.logger.Warn("WebsocketConnection.readAll() message exceeds the limit", "maxSize", maxSize)
+		ws.socket.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseMessageTooBig, errMessageTooLarge.Error()),
+			time.Now().Add(ws.sendTimeoutOrDefault()))
+		ws.socket.Close()
+		return nil, errMessageTooLarge
+	}
+	if err != nil {
+		return nil, errBadBuffer
+	}
+
+	return data, nil
+}
+
 // WriteMessage message m into a connection
 func (ws *WebsocketConnection) WriteMessage(m string) error {
-	ws.transport.logger.Debug("WebsocketConnection.WriteMessage() fired with:", zap.String("m", m))
-	ws.socket.SetWriteDeadline(time.Now().Add(ws.transport.SendTimeout))
+	if logging.DebugEnabled(ws.transport.logger) {
+		ws.transport.logger.Debug("WebsocketConnection.WriteMessage() fired with:", "m", m)
+	}
+	return ws.write(websocket.TextMessage, []byte(m), true)
+}
+
+// WriteMessageNoCompress writes message m into a connection without the per-message compression
+func (ws *WebsocketConnection) WriteMessageNoCompress(m string) error {
+	if logging.DebugEnabled(ws.transport.logger) {
+		ws.transport.logger.Debug("WebsocketConnection.WriteMessageNoCompress() fired with:", "m", m)
+	}
+	return ws.write(websocket.TextMessage, []byte(m), false)
+}
 
-	writer, err := ws.socket.NextWriter(websocket.TextMessage)
+// WriteBinary message data into a connection, data is the engine.io binary frame starting with the message type.
+// The type is written only for the engine.io versions before v4
+func (ws *WebsocketConnection) WriteBinary(data []byte) error {
+	if logging.DebugEnabled(ws.transport.logger) {
+		ws.transport.logger.Debug("WebsocketConnection.WriteBinary() fired with:", "len", len(data))
+	}
+	if ws.eio == eioVersion4 {
+		if len(data) == 0 || data[0] != binaryMessageType {
+			return errPacketWrong
+		}
+		data = data[1:]
+	}
+	return ws.write(websocket.BinaryMessage, data, true)
+}
+
+// write data as a message of the given type into a connection, compress is false to disable
+// the negotiated per-message compression for the message
+func (ws *WebsocketConnection) write(msgType int, data []byte, compress bool) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+
+	if !compress {
+		ws.socket.EnableWriteCompression(false)
+		defer ws.socket.EnableWriteCompression(true)
+	}
+
+	ws.socket.SetWriteDeadline(time.Now().Add(ws.sendTimeoutOrDefault()))
+
+	writer, err := ws.socket.NextWriter(msgType)
 	if err != nil {
-		return err
+		return wrapError(err)
 	}
 
-	if _, err := writer.Write([]byte(m)); err != nil {
-		return err
+	if _, err := writer.Write(data); err != nil {
+		return wrapError(err)
 	}
 
-	return writer.Close()
+	return wrapError(writer.Close())
 }
 
-// Close the connection
+// Close the connection with the normal closure code
 func (ws *WebsocketConnection) Close() error {
-	ws.transport.logger.Debug("WebsocketConnection.Close() fired")
-	return ws.socket.Close()
+	return ws.CloseWithReason(websocket.CloseNormalClosure, "")
+}
+
+// CloseWithReason closes the connection gracefully with the given websocket close code and reason. The close frame
+// is sent, then the network connection is closed in background once the peer's close frame is read,
+// or after CloseTimeout
+func (ws *WebsocketConnection) CloseWithReason(code int, reason string) error {
+	ws.transport.logger.Debug("WebsocketConnection.CloseWithReason() fired", "code", code, "reason", reason)
+	err := ws.socket.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(ws.sendTimeoutOrDefault()))
+	if err != nil {
+		return ws.socket.Close()
+	}
+
+	go func() {
+		select {
+		case <-ws.readDoneC:
+		case <-time.After(ws.transport.CloseTimeout):
+			ws.transport.logger.Debug("WebsocketConnection.CloseWithReason() timed out waiting for the peer's close")
+		}
+		ws.socket.Close()
+	}()
+	return nil
 }
 
 // PingParams returns ping params
@@ -0,0 +1,67 @@
+package transport
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebsocketBinaryFrameByEIO(t *testing.T) {
+	tests := []struct {
+		eio  string
+		wire []byte
+	}{
+		{"3", []byte{4, 1, 2, 3}},
+		{"4", []byte{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run("EIO"+tt.eio, func(t *testing.T) {
+			connC := make(chan Connection, 1)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := DefaultWebsocketTransport().HandleConnection(w, r)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				connC <- conn
+			}))
+			defer ts.Close()
+
+			url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/?EIO=" + tt.eio + "&transport=websocket"
+			peer, _, err := websocket.DefaultDialer.Dial(url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer peer.Close()
+			conn := <-connC
+			defer conn.Close()
+
+			frame := []byte{4, 1, 2, 3}
+			if err := conn.WriteBinary(frame); err != nil {
+				t.Fatalf("WriteBinary() error = %v", err)
+			}
+			msgType, wire, err := peer.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msgType != websocket.BinaryMessage || !bytes.Equal(wire, tt.wire) {
+				t.Fatalf("written frame = %d %v, want binary %v", msgType, wire, tt.wire)
+			}
+
+			if err := peer.WriteMessage(websocket.BinaryMessage, tt.wire); err != nil {
+				t.Fatal(err)
+			}
+			got, err := conn.GetBinary()
+			if err != nil {
+				t.Fatalf("GetBinary() error = %v", err)
+			}
+			if !bytes.Equal(got, frame) {
+				t.Fatalf("GetBinary() = %v, want %v", got, frame)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package socketio
+
+import (
+	"time"
+
+	"github.com/vanti-dev/golang-socketio/protocol"
+	"github.com/vanti-dev/golang-socketio/transport"
+)
+
+// upgradeDrainInterval is how often the polling connection queue is drained while its outgoing loop is stopping
+const upgradeDrainInterval = 10 * time.Millisecond
+
+// queuedPacket is an event packet pending at the transport upgrade with its binary attachments
+type queuedPacket struct {
+	packet      string
+	attachments [][]byte
+}
+
+// stopOutLoop stops the outgoing loop of the polling channel at the transport upgrade and returns the event
+// packets it queued to the polling connection, but not sent yet. The connection queue is drained while waiting,
+// so the loop isn't blocked writing to the full queue
+func (c *Channel) stopOutLoop() []queuedPacket {
+	close(c.outStopC)
+
+	conn, _ := c.conn.(*transport.PollingConnection)
+	ticker := time.NewTicker(upgradeDrainInterval)
+	defer ticker.Stop()
+
+	var messages []string
+	for stopped := false; !stopped; {
+		select {
+		case <-c.outDoneC:
+			stopped = true
+		case <-ticker.C:
+		}
+		if conn != nil {
+			messages = append(messages, conn.Drain()...)
+		}
+	}
+
+	var packets []queuedPacket
+	for i := 0; i < len(messages); i++ {
+		if p, ok := c.queuedBinaryPacket(conn, messages[i]); ok {
+			packets = append(packets, p)
+			continue
+		}
+		if !protocol.IsEvent(messages[i]) {
+			continue
+		}
+		p := queuedPacket{packet: messages[i]}
+		if protocol.IsBinary(p.packet) {
+			// the base64 encoded attachments follow the binary packet
+			m, err := protocol.Decode(p.packet)
+			if err != nil || i+len(m.Attachments) >= len(messages) {
+				c.logger.Warn("Channel.stopOutLoop() dropped the malformed binary packet", "sid", c.Id())
+				continue
+			}
+			frames := messages[i+1 : i+1+len(m.Attachments)]
+			i += len(frames)
+			if p.attachments, err = decodeQueuedAttachments(conn, frames); err != nil {
+				c.logger.Warn("Channel.stopOutLoop() dropped the binary packet", "sid", c.Id(), "err", err)
+				continue
+			}
+		}
+		packets = append(packets, p)
+	}
+	return packets
+}
+
+// queuedBinaryPacket returns the event packet of the binary codec from the base64 encoded binary message m queued
+// to the connection, false is returned for another message. The attachments of the binary packets are skipped
+// by the caller, so the binary message isn't one of them
+func (c *Channel) queuedBinaryPacket(conn *transport.PollingConnection, m string) (queuedPacket, bool) {
+	if _, ok := c.codec.(protocol.BinaryCodec); !ok || conn == nil {
+		return queuedPacket{}, false
+	}
+	frame, err := conn.DecodeBinary(m)
+	if err != nil {
+		return queuedPacket{}, false
+	}
+	data, err := protocol.DecodeAttachment(frame)
+	if err != nil {
+		return queuedPacket{}, false
+	}
+
+	packet := binaryPacketPrefix + string(data)
+	return queuedPacket{packet: packet}, c.isQueuedEvent(packet)
+}
+
+// isQueuedEvent checks if the queued packet is an event or an ack one, including the packets of the binary codec
+func (c *Channel) isQueuedEvent(packet string) bool {
+	data, binary := splitBinary(packet)
+	if !binary {
+		return protocol.IsEvent(packet)
+	}
+	codec, ok := c.codec.(protocol.BinaryCodec)
+	if !ok {
+		return false
+	}
+	m, err := codec.DecodeBinary([]byte(data))
+	return err == nil && isEventType(m.Type)
+}
+
+// decodeQueuedAttachments returns the attachments of the base64 encoded binary messages queued to the connection
+func decodeQueuedAttachments(conn *transport.PollingConnection, messages []string) ([][]byte, error) {
+	attachments := make([][]byte, len(messages))
+	for i, m := range messages {
+		frame, err := conn.DecodeBinary(m)
+		if err != nil {
+			return nil, err
+		}
+		if attachments[i], err = protocol.DecodeAttachment(frame); err != nil {
+			return nil, err
+		}
+	}
+	return attachments, nil
+}
+
+// drainQueued returns the event packets left at the outgoing queue of the channel with their attachments,
+// the outgoing loop should be stopped
+func (c *Channel) drainQueued() []queuedPacket {
+	// the binary packet and its attachments are queued under binaryMu, so they're taken together
+	c.binaryMu.Lock()
+	defer c.binaryMu.Unlock()
+
+	var packets []queuedPacket
+	for {
+		select {
+		case m := <-c.outC:
+			packet, _ := splitCompress(m)
+			p := queuedPacket{packet: m}
+			if protocol.IsBinary(packet) {
+				p.attachments = <-c.binaryC
+			}
+			if c.isQueuedEvent(packet) {
+				packets = append(packets, p)
+			}
+		default:
+			return packets
+		}
+	}
+}
+
+// enqueuePending queues the packets pending at the polling channel to the upgraded channel c without blocking,
+// the ones which don't fit the outgoing queue are dropped
+func (c *Channel) enqueuePending(packets []queuedPacket) {
+	for _, p := range packets {
+		if !c.enqueue(p.packet, p.attachments, false) {
+			c.logger.Warn("Channel.enqueuePending() dropped the packet pending at the upgrade", "sid", c.Id())
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package socketio
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// upgrade upgrades the polling session sid of the test HTTP server to the websocket one
+// and returns the websocket connection once the server replaced the polling channel
+func upgrade(t *testing.T, s *Server, ts *httptest.Server, sid string) *websocket.Conn {
+	t.Helper()
+	polling, err := s.GetChannel(sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial(websocketURL(ts)+"&sid="+sid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("2probe")); err != nil {
+		t.Fatal(err)
+	}
+	if _, m, err := ws.ReadMessage(); err != nil || string(m) != "3probe" {
+		t.Fatalf("probe response = %q, %v, want 3probe", m, err)
+	}
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("5")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for polling.upgradedChannel() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the polling channel isn't upgraded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return ws
+}
+
+func TestUpgradeKeepsHeartbeat(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.SetOpenSequenceHook(func(h *ConnectionHeader, c *Channel) {
+		h.PingInterval, h.PingTimeout = 1234, 5678
+	})
+
+	sid := openPolling(t, ts.URL)
+	upgrade(t, s, ts, sid)
+
+	c, err := s.GetChannel(sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interval, timeout := c.pingParams()
+	if interval != 1234*time.Millisecond || timeout != 5678*time.Millisecond {
+		t.Fatalf("upgraded channel ping params = %v, %v, want 1.234s, 5.678s", interval, timeout)
+	}
+}
+
+func TestUpgradeKeepsRooms(t *testing.T) {
+	s, ts := newTestServer(t)
+	sid := openPolling(t, ts.URL)
+	polling, err := s.GetChannel(sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := polling.Join("before"); err != nil {
+		t.Fatal(err)
+	}
+
+	upgrade(t, s, ts, sid)
+
+	// the polling channel kept by the application acts on the upgraded one
+	if _, err := polling.Join("after"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.RoomMembers("before"); len(got) != 1 || got[0] != sid {
+		t.Fatalf("RoomMembers(before) = %v, want [%s]", got, sid)
+	}
+	if got := s.RoomMembers("after"); len(got) != 1 || got[0] != sid {
+		t.Fatalf("RoomMembers(after) = %v, want [%s]", got, sid)
+	}
+	if got := polling.Rooms(); len(got) != 2 {
+		t.Fatalf("Rooms() = %v, want both rooms", got)
+	}
+
+	if _, err := polling.Leave("before"); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Amount("before"); n != 0 {
+		t.Fatalf("Amount(before) = %d after Leave, want 0", n)
+	}
+	if err := polling.LeaveAll(); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Amount("after"); n != 0 {
+		t.Fatalf("Amount(after) = %d after LeaveAll, want 0", n)
+	}
+}
+
+func TestUpgradeMovesPendingMessages(t *testing.T) {
+	s, ts := newTestServer(t)
+	sid := openPollingQuery(t, ts.URL, "EIO=3&transport=polling&b64=1")
+	c, err := s.GetChannel(sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const total = 60
+	attachment := []byte{1, 2, 3}
+	emit := func(i int) {
+		if i%20 == 5 {
+			if err := c.Emit("bin", attachment); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := c.Emit("seq", i); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// the first messages are queued to the polling connection, as nobody polls,
+	// the rest are emitted during the upgrade
+	for i := 0; i < 10; i++ {
+		emit(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 10; i < total; i++ {
+			emit(i)
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	ws := upgrade(t, s, ts, sid)
+	<-done
+
+	seen := make(map[string]bool)
+	binaries := 0
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(seen) < total || binaries < total/20 {
+		_, m, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("got %d events and %d binary ones, want %d and %d: %v", len(seen), binaries, total, total/20, err)
+		}
+		switch packet := string(m); {
+		case strings.HasPrefix(packet, `42["seq"`):
+			if seen[packet] {
+				t.Fatalf("%s is delivered twice", packet)
+			}
+			seen[packet] = true
+		case strings.HasPrefix(packet, `451-["bin"`):
+			msgType, frame, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := append([]byte{4}, attachment...); msgType != websocket.BinaryMessage || !bytes.Equal(frame, want) {
+				t.Fatalf("attachment frame = %d %v, want binary %v", msgType, frame, want)
+			}
+			binaries++
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package socketio
+
+import (
+	"reflect"
+
+	"github.com/vanti-dev/golang-socketio/protocol"
+)
+
+// SetValidator sets the function validating the decoded event args before the handler is called, e.g. wrapping
+// Struct of the go-playground validator checking the `validate` struct tags. It's called with the pointer
+// to every decoded struct argument of the handler, the other arguments aren't validated. If it returns an error,
+// the handler isn't called and the error is reported to the OnError handler as for the args decoding failure
+func (s *Server) SetValidator(fn func(interface{}) error) { s.event.validator = fn }
+
+// validateArg validates the decoded handler argument v with the validator, if it's set and v points to a struct,
+// possibly through several pointers, e.g. for a pointer parameter. The validator is called with the pointer
+// to the struct, nil pointers aren't validated
+func (e *event) validateArg(v interface{}) error {
+	if e.validator == nil {
+		return nil
+	}
+
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr && !value.IsNil() && value.Elem().Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	return e.validator(value.Interface())
+}
+
+// decodeArg decodes event args data into the handler argument v and validates it
+func (e *event) decodeArg(data []byte, v interface{}) error {
+	if err := e.unmarshal(data, v); err != nil {
+		return err
+	}
+	return e.validateArg(v)
+}
+
+// argDecoder returns the function decoding the args received by the channel c into the handler argument
+// and validating it, the args are decoded by the channel codec if it encodes them
+func (e *event) argDecoder(c *Channel) unmarshalFunc {
+	codec, ok := c.codec.(protocol.ArgsCodec)
+	if !ok {
+		return e.decodeArg
+	}
+	return func(data []byte, v interface{}) error {
+		if err := codec.UnmarshalArgs(string(data), v); err != nil {
+			return err
+		}
+		return e.validateArg(v)
+	}
+}
@@ -0,0 +1,45 @@
+package socketio
+
+import (
+	"errors"
+	"testing"
+)
+
+type validatedArg struct{ Name string }
+
+func TestValidateArg(t *testing.T) {
+	errInvalid := errors.New("invalid")
+	e := &event{validator: func(v interface{}) error {
+		arg, ok := v.(*validatedArg)
+		if !ok {
+			t.Fatalf("validator called with %T, want *validatedArg", v)
+		}
+		if arg.Name == "" {
+			return errInvalid
+		}
+		return nil
+	}}
+
+	valid, invalid := &validatedArg{Name: "a"}, &validatedArg{}
+	var nilArg *validatedArg
+	number := 1
+
+	tests := []struct {
+		name string
+		v    interface{}
+		want error
+	}{
+		{"struct", valid, nil},
+		{"invalid struct", invalid, errInvalid},
+		{"pointer param", &valid, nil},
+		{"invalid pointer param", &invalid, errInvalid},
+		{"nil pointer param", &nilArg, nil},
+		{"not struct", &number, nil},
+		{"nil", nil, nil},
+	}
+	for _, tt := range tests {
+		if err := e.validateArg(tt.v); err != tt.want {
+			t.Errorf("%s: validateArg() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}